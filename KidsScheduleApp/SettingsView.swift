@@ -4,10 +4,45 @@ import UserNotifications
 struct SettingsView: View {
     @State private var showingNotificationSettings = false
     @State private var notificationStatus = "检查中..."
+    @State private var loginUsername = ""
+    @State private var loginPassword = ""
+    @State private var loginError = ""
+    @ObservedObject private var webSocketManager = WebSocketManager.shared
     
     var body: some View {
         NavigationView {
             List {
+                // 账号部分：服务器要求登录后才能同步
+                Section(header: Text("账号")) {
+                    if webSocketManager.authToken == nil {
+                        TextField("用户名", text: $loginUsername)
+                            .textContentType(.username)
+                            .autocapitalization(.none)
+                        SecureField("密码", text: $loginPassword)
+                            .textContentType(.password)
+                        if !loginError.isEmpty {
+                            Text(loginError)
+                                .font(.caption)
+                                .foregroundColor(.red)
+                        }
+                        Button("登录") {
+                            login()
+                        }
+                        .disabled(loginUsername.isEmpty || loginPassword.isEmpty)
+                    } else {
+                        Button(action: {
+                            Task { await webSocketManager.logout() }
+                        }) {
+                            HStack {
+                                Image(systemName: "rectangle.portrait.and.arrow.right")
+                                    .foregroundColor(.red)
+                                Text("退出登录")
+                                    .foregroundColor(.red)
+                            }
+                        }
+                    }
+                }
+
                 // WebSocket实时同步部分
                 Section(header: Text("实时同步")) {
                     NavigationLink(destination: WebSocketStatusView()) {
@@ -188,6 +223,17 @@ struct SettingsView: View {
         print("清除缓存功能待实现")
     }
 
+    private func login() {
+        loginError = ""
+        Task {
+            if await webSocketManager.login(username: loginUsername, password: loginPassword) {
+                loginPassword = ""
+            } else {
+                loginError = "用户名或密码错误"
+            }
+        }
+    }
+
     private func clearAllData() {
         // 清除本地Core Data数据
         WebSocketManager.shared.clearAllLocalTasks()
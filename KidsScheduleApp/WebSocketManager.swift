@@ -109,7 +109,12 @@ class WebSocketManager: NSObject, ObservableObject {
     }
     
     private var webSocketTask: URLSessionWebSocketTask?
+    private let serverURL = "http://ec2-18-183-213-175.ap-northeast-1.compute.amazonaws.com:8082"
     private let baseURL = "ws://ec2-18-183-213-175.ap-northeast-1.compute.amazonaws.com:8082/ws"
+    private static let tokenKey = "authToken"
+
+    // 登录后服务器签发的token，保存在UserDefaults里
+    @Published private(set) var authToken: String? = UserDefaults.standard.string(forKey: WebSocketManager.tokenKey)
     
     private override init() {
         super.init()
@@ -120,13 +125,20 @@ class WebSocketManager: NSObject, ObservableObject {
     // MARK: - 连接管理
     
     func connect() {
+        guard let token = authToken else {
+            connectionStatus = "未登录"
+            print("⚠️ 尚未登录，跳过WebSocket连接")
+            return
+        }
         guard let url = URL(string: baseURL) else {
             print("❌ WebSocket URL无效")
             return
         }
         
+        var request = URLRequest(url: url)
+        request.setValue("Bearer \(token)", forHTTPHeaderField: "Authorization")
         let urlSession = URLSession(configuration: .default)
-        webSocketTask = urlSession.webSocketTask(with: url)
+        webSocketTask = urlSession.webSocketTask(with: request)
         
         webSocketTask?.resume()
         
@@ -148,6 +160,53 @@ class WebSocketManager: NSObject, ObservableObject {
         print("🔌 WebSocket已断开")
     }
     
+    // MARK: - 登录
+
+    // 用服务器上注册的账号登录，成功后保存token并重新连接
+    func login(username: String, password: String) async -> Bool {
+        guard let url = URL(string: serverURL + "/api/auth/login") else {
+            return false
+        }
+        var request = URLRequest(url: url)
+        request.httpMethod = "POST"
+        request.setValue("application/json", forHTTPHeaderField: "Content-Type")
+        request.httpBody = try? JSONSerialization.data(withJSONObject: ["username": username, "password": password])
+
+        do {
+            let (data, response) = try await URLSession.shared.data(for: request)
+            guard let httpResponse = response as? HTTPURLResponse, httpResponse.statusCode == 200,
+                  let json = try JSONSerialization.jsonObject(with: data) as? [String: Any],
+                  let token = json["token"] as? String else {
+                print("❌ 登录失败")
+                return false
+            }
+            authToken = token
+            UserDefaults.standard.set(token, forKey: Self.tokenKey)
+            print("✅ 登录成功: \(username)")
+
+            disconnect()
+            connect()
+            return true
+        } catch {
+            print("❌ 登录请求失败: \(error)")
+            return false
+        }
+    }
+
+    func logout() async {
+        if let token = authToken, let url = URL(string: serverURL + "/api/auth/logout") {
+            var request = URLRequest(url: url)
+            request.httpMethod = "POST"
+            request.setValue("Bearer \(token)", forHTTPHeaderField: "Authorization")
+            _ = try? await URLSession.shared.data(for: request)
+        }
+        authToken = nil
+        UserDefaults.standard.removeObject(forKey: Self.tokenKey)
+        disconnect()
+        connectionStatus = "未登录"
+        print("👋 已退出登录")
+    }
+
     // MARK: - 消息处理
     
     private func receiveMessage() {
@@ -62,7 +62,7 @@ deploy_to_server() {
     go mod tidy
     
     # 交叉编译为Linux版本
-    GOOS=linux GOARCH=amd64 CGO_ENABLED=1 go build -o websocket-server-linux main.go
+    GOOS=linux GOARCH=amd64 CGO_ENABLED=1 go build -o websocket-server-linux .
     
     if [ $? -ne 0 ]; then
         echo "❌ 编译失败"
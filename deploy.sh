@@ -19,7 +19,7 @@ echo "🚀 开始部署WebSocket服务器..."
 # 1. 编译本地代码
 echo "📦 编译Go程序..."
 cd websocket-server
-GOOS=linux GOARCH=amd64 go build -o ${REMOTE_BINARY} main.go
+GOOS=linux GOARCH=amd64 go build -o ${REMOTE_BINARY} .
 cd ..
 
 # 2. 停止远程服务器上的旧进程
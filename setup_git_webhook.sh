@@ -55,7 +55,7 @@ fi
 
 # 下载依赖并编译
 go mod tidy
-GOOS=linux GOARCH=amd64 CGO_ENABLED=1 go build -o websocket-server-linux main.go
+GOOS=linux GOARCH=amd64 CGO_ENABLED=1 go build -o websocket-server-linux .
 
 if [ $? -ne 0 ]; then
     echo "$(date): 编译失败" >> $LOG_FILE
@@ -11,13 +11,17 @@ class TaskManager {
         this.currentSort = 'created_desc';
         this.searchQuery = '';
         this.currentView = 'list';
+        this.serverUrl = 'http://ec2-18-183-213-175.ap-northeast-1.compute.amazonaws.com:8082';
+        this.token = localStorage.getItem('authToken');
+        this.heartbeatTimer = null;
 
         this.init();
     }
 
     init() {
         this.setupEventListeners();
-        this.connectWebSocket();
+        this.setupLogin();
+        this.ensureLogin();
         this.setDefaultDueDate();
         this.setupFilters();
         this.setupSearch();
@@ -68,6 +72,113 @@ class TaskManager {
         }, 100);
     }
 
+    setupLogin() {
+        document.getElementById('loginForm').addEventListener('submit', (e) => {
+            e.preventDefault();
+            this.login();
+        });
+    }
+
+    // 带上Bearer token的REST请求，token失效时回到登录框
+    async authFetch(path, options = {}) {
+        const headers = { ...(options.headers || {}) };
+        if (this.token) {
+            headers['Authorization'] = `Bearer ${this.token}`;
+        }
+        const response = await fetch(this.serverUrl + path, { ...options, headers });
+        if (response.status === 401) {
+            this.clearSession();
+            this.showLoginModal();
+        }
+        return response;
+    }
+
+    // 已保存的token仍然有效就直接连接，否则弹出登录框
+    async ensureLogin() {
+        if (!this.token) {
+            this.showLoginModal();
+            return;
+        }
+        try {
+            const response = await this.authFetch('/api/auth/me');
+            if (response.ok) {
+                this.connectWebSocket();
+            }
+        } catch (error) {
+            console.error('❌ 校验登录状态失败:', error);
+            this.updateConnectionStatus('disconnected', '连接失败');
+        }
+    }
+
+    async login() {
+        const username = document.getElementById('loginUsername').value.trim();
+        const password = document.getElementById('loginPassword').value;
+        const errorElement = document.getElementById('loginError');
+        errorElement.textContent = '';
+
+        try {
+            const response = await fetch(this.serverUrl + '/api/auth/login', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ username, password })
+            });
+            if (!response.ok) {
+                errorElement.textContent = response.status === 401 ? '用户名或密码错误' : '登录失败，请稍后重试';
+                return;
+            }
+            const result = await response.json();
+            this.token = result.token;
+            localStorage.setItem('authToken', this.token);
+            console.log('✅ 登录成功:', result.user && result.user.username);
+
+            this.hideLoginModal();
+            this.reconnectAttempts = 0;
+            this.connectWebSocket();
+        } catch (error) {
+            console.error('❌ 登录失败:', error);
+            errorElement.textContent = '无法连接服务器';
+        }
+    }
+
+    async logout() {
+        try {
+            await this.authFetch('/api/auth/logout', { method: 'POST' });
+        } catch (error) {
+            console.error('❌ 退出登录失败:', error);
+        }
+        this.clearSession();
+        this.tasks = [];
+        this.applyFilters();
+        this.showLoginModal();
+    }
+
+    clearSession() {
+        this.token = null;
+        localStorage.removeItem('authToken');
+        if (this.ws) {
+            // 主动断开时不再自动重连
+            this.reconnectAttempts = this.maxReconnectAttempts;
+            this.ws.close();
+            this.ws = null;
+        }
+    }
+
+    showLoginModal() {
+        document.getElementById('loginForm').reset();
+        document.getElementById('loginError').textContent = '';
+        document.getElementById('loginModal').classList.add('show');
+        document.body.style.overflow = 'hidden';
+        this.updateConnectionStatus('disconnected', '未登录');
+        setTimeout(() => {
+            document.getElementById('loginUsername').focus();
+        }, 100);
+    }
+
+    hideLoginModal() {
+        document.getElementById('loginModal').classList.remove('show');
+        document.body.style.overflow = '';
+    }
+
     setupFilters() {
         const filterBtns = document.querySelectorAll('.filter-btn');
         filterBtns.forEach(btn => {
@@ -138,8 +249,13 @@ class TaskManager {
     }
 
     connectWebSocket() {
-        const wsUrl = 'ws://ec2-18-183-213-175.ap-northeast-1.compute.amazonaws.com:8082/ws';
-        
+        if (!this.token) {
+            this.showLoginModal();
+            return;
+        }
+        // 浏览器的WebSocket无法设置请求头，token通过查询参数传给服务器
+        const wsUrl = this.serverUrl.replace(/^http/, 'ws') + '/ws?token=' + encodeURIComponent(this.token);
+
         try {
             this.ws = new WebSocket(wsUrl);
             this.updateConnectionStatus('connecting', '连接中...');
@@ -167,13 +283,16 @@ class TaskManager {
             this.ws.onclose = (event) => {
                 console.log('🔌 WebSocket连接关闭:', event.code, event.reason);
                 this.isConnected = false;
+                if (!this.token) {
+                    return; // 已退出登录
+                }
                 this.updateConnectionStatus('disconnected', '连接断开');
                 
-                // 尝试重连
+                // 尝试重连，先确认token仍然有效
                 if (this.reconnectAttempts < this.maxReconnectAttempts) {
                     this.reconnectAttempts++;
                     console.log(`🔄 尝试重连 (${this.reconnectAttempts}/${this.maxReconnectAttempts})`);
-                    setTimeout(() => this.connectWebSocket(), this.reconnectDelay);
+                    setTimeout(() => this.ensureLogin(), this.reconnectDelay);
                 }
             };
 
@@ -322,7 +441,8 @@ class TaskManager {
     }
 
     startHeartbeat() {
-        setInterval(() => {
+        clearInterval(this.heartbeatTimer);
+        this.heartbeatTimer = setInterval(() => {
             if (this.isConnected) {
                 this.sendPing();
             }
@@ -387,7 +507,6 @@ class TaskManager {
         } else {
             // 创建新任务
             const task = {
-                user_id: 'default_user',
                 title: title,
                 description: description,
                 category: category,
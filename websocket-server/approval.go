@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// 完成审批状态
+const (
+	ApprovalNone     = ""
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+)
+
+// ErrNoPendingCompletion 任务没有待审批的完成申请
+var ErrNoPendingCompletion = errors.New("任务没有待审批的完成申请")
+
+// 审批字段只能由审批流程修改，客户端提交的值一律以服务器为准
+func keepApprovalFields(current, updated *Task) {
+	updated.ApprovalStatus = current.ApprovalStatus
+	updated.ApprovalComment = current.ApprovalComment
+	updated.CompletionRequestedAt = current.CompletionRequestedAt
+	updated.ReviewedBy = current.ReviewedBy
+	updated.ReviewedAt = current.ReviewedAt
+}
+
+// 根据完成状态的变化推进审批流程，返回是否产生了新的完成申请。
+// 孩子把任务标记为完成时不会直接完成，而是进入pending等待家长审批；
+// 家长直接完成一个待审批的任务视为批准
+func applyCompletionWorkflow(actorID string, current, updated *Task) (bool, error) {
+	// 待审批的任务保存的仍是未完成，孩子撤回申请时提交的is_completed=false与原值相同，不能直接跳过
+	pending := current.ApprovalStatus == ApprovalPending
+	if updated.IsCompleted == current.IsCompleted && !pending {
+		return false, nil
+	}
+
+	child, err := isChild(actorID)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().UTC().Format(timeLayout)
+
+	if child {
+		if updated.IsCompleted {
+			updated.IsCompleted = false
+			if pending {
+				return false, nil
+			}
+			updated.ApprovalStatus = ApprovalPending
+			updated.ApprovalComment = ""
+			updated.CompletionRequestedAt = now
+			return true, nil
+		}
+		// 孩子撤回完成或撤回待审批的申请
+		if pending {
+			log.Printf("↩️ 完成申请已撤回: %s", current.Title)
+		}
+		updated.ApprovalStatus = ApprovalNone
+		updated.CompletionRequestedAt = ""
+		return false, nil
+	}
+
+	if updated.IsCompleted && pending {
+		updated.ApprovalStatus = ApprovalApproved
+		updated.ReviewedBy = actorID
+		updated.ReviewedAt = now
+	}
+	return false, nil
+}
+
+// ReviewCompletion 家长批准或驳回孩子的完成申请，occurrenceDate非空时审批重复任务的某次实例
+func (s *TaskService) ReviewCompletion(actorID, taskID, occurrenceDate string, approve bool, comment string) (*Task, error) {
+	task, err := s.GetTaskForUser(actorID, taskID)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := isParentOf(actorID, task.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || actorID == task.UserID {
+		return nil, ErrForbidden
+	}
+	if occurrenceDate != "" {
+		return s.reviewOccurrence(actorID, task, occurrenceDate, approve, comment)
+	}
+	if task.ApprovalStatus != ApprovalPending {
+		return nil, ErrNoPendingCompletion
+	}
+
+	wasCompleted := task.IsCompleted
+	task.IsCompleted = approve
+	task.ApprovalStatus = ApprovalRejected
+	if approve {
+		task.ApprovalStatus = ApprovalApproved
+	}
+	task.ApprovalComment = comment
+	task.ReviewedBy = actorID
+	task.ReviewedAt = time.Now().UTC().Format(timeLayout)
+
+	if err := s.store.Update(task); err != nil {
+		return nil, err
+	}
+	log.Printf("📝 任务审批完成: %s -> %s", task.Title, task.ApprovalStatus)
+
+	broadcastTaskChange("task_updated", task)
+	if approve {
+		broadcastTaskChange("completion_approved", task)
+	} else {
+		broadcastTaskChange("completion_rejected", task)
+	}
+	settleTaskPoints(actorID, task, wasCompleted)
+	return task, nil
+}
+
+// 审批REST处理器: POST /api/tasks/{id}/approve 和 /api/tasks/{id}/reject，
+// body为 {comment, occurrence_date}，审批重复任务实例时需要occurrence_date
+func reviewCompletionHandler(approve bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Comment        string `json:"comment"`
+			OccurrenceDate string `json:"occurrence_date"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		task, err := taskService.ReviewCompletion(userFromContext(r.Context()).ID, mux.Vars(r)["id"],
+			req.OccurrenceDate, approve, req.Comment)
+		if err != nil {
+			writeOccurrenceError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(task)
+	}
+}
+
+// WebSocket审批消息: approve_completion / reject_completion，data为 {id, comment, occurrence_date}
+func handleReviewCompletion(client *Client, data interface{}, approve bool) (*Task, error) {
+	taskMap, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidMessage
+	}
+
+	task, err := taskService.ReviewCompletion(client.userID, getString(taskMap, "id"),
+		getString(taskMap, "occurrence_date"), approve, getString(taskMap, "comment"))
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("✅ 任务审批成功: %s", task.Title)
+	return task, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import "testing"
+
+func TestCompletionRequestWithdrawal(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, task *Task)
+		want   string
+		done   bool
+	}{
+		{"孩子再次提交完成保持申请", func(t *testing.T, task *Task) {
+			task.IsCompleted = true
+			if err := taskService.UpdateTask("child", task); err != nil {
+				t.Fatal(err)
+			}
+		}, ApprovalPending, false},
+		{"孩子提交未完成撤回申请", func(t *testing.T, task *Task) {
+			task.IsCompleted = false
+			if err := taskService.UpdateTask("child", task); err != nil {
+				t.Fatal(err)
+			}
+		}, ApprovalNone, false},
+		{"孩子的补丁没有is_completed时保持申请", func(t *testing.T, task *Task) {
+			if _, err := patchTask("child", task, []byte(`{"daily_progress": "{\"2026-10-01\": 50}"}`), false, 0, false); err != nil {
+				t.Fatal(err)
+			}
+		}, ApprovalPending, false},
+		{"孩子用补丁撤回申请", func(t *testing.T, task *Task) {
+			if _, err := patchTask("child", task, []byte(`{"is_completed": false}`), false, 0, false); err != nil {
+				t.Fatal(err)
+			}
+		}, ApprovalNone, false},
+		{"家长提交未完成不影响申请", func(t *testing.T, task *Task) {
+			task.IsCompleted = false
+			task.Title = "练琴30分钟"
+			if err := taskService.UpdateTask("parent", task); err != nil {
+				t.Fatal(err)
+			}
+		}, ApprovalPending, false},
+		{"家长直接完成视为批准", func(t *testing.T, task *Task) {
+			task.IsCompleted = true
+			if err := taskService.UpdateTask("parent", task); err != nil {
+				t.Fatal(err)
+			}
+		}, ApprovalApproved, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupPermissionFamilies(t)
+			task := createTestTask(t, "parent", Task{UserID: "child", Title: "练琴"})
+
+			// 孩子申请完成后任务仍是未完成，等待审批
+			task.IsCompleted = true
+			if err := taskService.UpdateTask("child", task); err != nil {
+				t.Fatal(err)
+			}
+			requested, err := taskService.store.Get(getTaskIDString(task))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if requested.IsCompleted || requested.ApprovalStatus != ApprovalPending {
+				t.Fatalf("申请后 is_completed=%v, approval_status=%q", requested.IsCompleted, requested.ApprovalStatus)
+			}
+
+			tt.change(t, requested)
+			saved, err := taskService.store.Get(getTaskIDString(task))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved.ApprovalStatus != tt.want || saved.IsCompleted != tt.done {
+				t.Errorf("approval_status=%q, is_completed=%v, want %q, %v", saved.ApprovalStatus, saved.IsCompleted, tt.want, tt.done)
+			}
+			if tt.want == ApprovalNone && saved.CompletionRequestedAt != "" {
+				t.Errorf("撤回后completion_requested_at = %q", saved.CompletionRequestedAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrUnauthorized 缺少token或token无效/过期
+	ErrUnauthorized = errors.New("未登录或登录已过期")
+	// ErrInvalidCredentials 用户名或密码错误
+	ErrInvalidCredentials = errors.New("用户名或密码错误")
+	// ErrUsernameTaken 用户名已被注册
+	ErrUsernameTaken = errors.New("用户名已存在")
+)
+
+const (
+	passwordIterations = 120000
+	passwordSaltSize   = 16
+	passwordKeySize    = 32
+	minPasswordLength  = 6
+)
+
+// User 用户账号
+type User struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	CreatedAt   string `json:"created_at"`
+}
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+// 从请求上下文中取出已认证的用户
+func userFromContext(ctx context.Context) *User {
+	user, _ := ctx.Value(userContextKey).(*User)
+	return user
+}
+
+// PBKDF2-HMAC-SHA256 (RFC 8018)
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var buf [4]byte
+	key := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], uint32(block))
+		prf.Write(buf[:])
+		u = prf.Sum(u[:0])
+
+		t := make([]byte, hashLen)
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// 密码哈希格式: pbkdf2-sha256$迭代次数$salt$hash
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2SHA256([]byte(password), salt, passwordIterations, passwordKeySize)
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func checkPassword(password, encoded string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	key := pbkdf2SHA256([]byte(password), salt, iterations, len(expected))
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func createUser(username, password, displayName string) (*User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, fmt.Errorf("用户名不能为空")
+	}
+	if len(password) < minPasswordLength {
+		return nil, fmt.Errorf("密码至少需要%d位", minPasswordLength)
+	}
+
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	suffix, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{
+		ID:          "user_" + suffix,
+		Username:    username,
+		DisplayName: displayName,
+		CreatedAt:   time.Now().UTC().Format(timeLayout),
+	}
+	_, err = db.Exec("INSERT INTO users (id, username, password_hash, display_name, created_at) VALUES (?, ?, ?, ?, ?)",
+		user.ID, user.Username, passwordHash, user.DisplayName, user.CreatedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return nil, ErrUsernameTaken
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+func getUserByID(id string) (*User, error) {
+	var user User
+	err := db.QueryRow("SELECT id, username, COALESCE(display_name, ''), COALESCE(created_at, '') FROM users WHERE id = ?", id).
+		Scan(&user.ID, &user.Username, &user.DisplayName, &user.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// 校验用户名和密码并签发新的token
+func login(username, password string) (string, time.Time, *User, error) {
+	var userID, passwordHash string
+	err := db.QueryRow("SELECT id, password_hash FROM users WHERE username = ?", strings.TrimSpace(username)).
+		Scan(&userID, &passwordHash)
+	if err == sql.ErrNoRows {
+		return "", time.Time{}, nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return "", time.Time{}, nil, err
+	}
+	if !checkPassword(password, passwordHash) {
+		return "", time.Time{}, nil, ErrInvalidCredentials
+	}
+
+	user, err := getUserByID(userID)
+	if err != nil {
+		return "", time.Time{}, nil, err
+	}
+
+	token, err := randomHex(32)
+	if err != nil {
+		return "", time.Time{}, nil, err
+	}
+	expiresAt := time.Now().UTC().Add(config.TokenTTL)
+	_, err = db.Exec("INSERT INTO auth_tokens (token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		hashToken(token), user.ID, time.Now().UTC().Format(timeLayout), expiresAt.Format(timeLayout))
+	if err != nil {
+		return "", time.Time{}, nil, err
+	}
+	return token, expiresAt, user, nil
+}
+
+func revokeToken(token string) error {
+	_, err := db.Exec("DELETE FROM auth_tokens WHERE token_hash = ?", hashToken(token))
+	return err
+}
+
+// 根据token查找用户，过期的token视为无效
+func userForToken(token string) (*User, error) {
+	if token == "" {
+		return nil, ErrUnauthorized
+	}
+	var userID string
+	err := db.QueryRow("SELECT user_id FROM auth_tokens WHERE token_hash = ? AND expires_at > ?",
+		hashToken(token), time.Now().UTC().Format(timeLayout)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return nil, ErrUnauthorized
+	}
+	if err != nil {
+		return nil, err
+	}
+	return getUserByID(userID)
+}
+
+// 从Authorization: Bearer头中取token；WebSocket握手时浏览器无法设置请求头，允许使用?token=参数
+func tokenFromRequest(r *http.Request, allowQuery bool) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+			return strings.TrimSpace(header[7:])
+		}
+		return ""
+	}
+	if allowQuery {
+		return r.URL.Query().Get("token")
+	}
+	return ""
+}
+
+func authenticateRequest(r *http.Request, allowQuery bool) (*User, error) {
+	return userForToken(tokenFromRequest(r, allowQuery))
+}
+
+// 认证中间件：校验Bearer token并把用户放入请求上下文
+func requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := authenticateRequest(r, false)
+		if err != nil {
+			if err != ErrUnauthorized {
+				log.Printf("❌ 校验token失败: %v", err)
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kids-schedule"`)
+			http.Error(w, ErrUnauthorized.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, user)))
+	})
+}
+
+// WebSocket来源检查：原生App不带Origin；浏览器只允许同源或配置的来源
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range config.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	log.Printf("🚫 拒绝WebSocket来源: %s", origin)
+	return false
+}
+
+// 认证相关REST处理器
+func registerHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username    string `json:"username"`
+		Password    string `json:"password"`
+		DisplayName string `json:"display_name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := createUser(req.Username, req.Password, req.DisplayName)
+	if err != nil {
+		if err == ErrUsernameTaken {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("👤 新用户注册: %s (%s)", user.Username, user.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, expiresAt, user, err := login(req.Username, req.Password)
+	if err != nil {
+		if err == ErrInvalidCredentials {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":      token,
+		"token_type": "Bearer",
+		"expires_at": expiresAt.Format(time.RFC3339),
+		"user":       user,
+	})
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if err := revokeToken(tokenFromRequest(r, false)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "已退出登录"})
+}
+
+func meHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(userFromContext(r.Context()))
+}
+
+// 启用认证之前创建的任务属于default_user，任何账号都看不到。
+// 运维通过子命令 claim-legacy <用户名> 把它们一次性转给一个已注册的账号
+const legacyTaskCondition = "COALESCE(user_id, '') IN ('', 'default_user')"
+
+func countLegacyTasks() (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM tasks WHERE " + legacyTaskCondition).Scan(&count)
+	return count, err
+}
+
+// 把所有旧任务（包括回收站中的）转给username，返回转移的任务数
+func claimLegacyTasks(username string) (int, error) {
+	var userID string
+	err := db.QueryRow("SELECT id FROM users WHERE username = ?", strings.TrimSpace(username)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("用户不存在: %s", username)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var ids []string
+	rows, err := tx.Query("SELECT id FROM tasks WHERE " + legacyTaskCondition)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	now := time.Now().UTC().Format(timeLayout)
+	_, err = tx.Exec(`UPDATE tasks SET user_id = ?,
+		created_by = CASE WHEN COALESCE(created_by, '') IN ('', 'default_user') THEN ? ELSE created_by END,
+		updated_at = ?, revision = COALESCE(revision, 1) + 1,
+		field_revisions = json_set(COALESCE(field_revisions, '{}'), '$.user_id', COALESCE(revision, 1) + 1)
+		WHERE `+legacyTaskCondition, userID, userID, now)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	// 写入变更日志，已登录的设备增量同步时能拿到这些任务
+	store := NewSQLiteTaskStore(db)
+	changeMu.Lock()
+	defer changeMu.Unlock()
+	for _, id := range ids {
+		task, err := store.Get(id)
+		if err == ErrTaskNotFound {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if _, err := recordTaskChange(task, ChangeUpsert); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 变更类型
+const (
+	ChangeUpsert = "upsert"
+	ChangeDelete = "delete"
+)
+
+// 记录变更和把广播放入Hub在同一把锁内完成，保证每个连接收到的游标是递增的：
+// 收到游标N的客户端一定已经收到了N之前的所有变更
+var changeMu sync.Mutex
+
+// Change 增量同步返回的一条变更，delete时只有task_id
+type Change struct {
+	Seq    int64  `json:"seq"`
+	Type   string `json:"type"`
+	TaskID string `json:"task_id"`
+	Task   *Task  `json:"task,omitempty"`
+}
+
+// ChangesResponse sync_since / GET /api/changes 的返回
+type ChangesResponse struct {
+	Changes []Change `json:"changes"`
+	Cursor  int64    `json:"cursor"`
+	HasMore bool     `json:"has_more"`
+	// 游标比服务器的序号还大（例如换了数据库），客户端需要全量同步
+	Reset bool `json:"reset,omitempty"`
+}
+
+// 哪些广播类型会改变任务，需要写入变更日志
+func changeTypeOf(broadcastType string) string {
+	switch broadcastType {
+	case "task_created", "task_updated", "task_restored", "occurrence_updated":
+		return ChangeUpsert
+	case "task_deleted":
+		return ChangeDelete
+	default:
+		return ""
+	}
+}
+
+// 能看到ownerID任务的用户：本人和其家庭中的家长
+func changeRecipients(ownerID string) ([]string, error) {
+	parents, err := queryStrings(`SELECT DISTINCT p.user_id FROM family_members p
+		JOIN family_members m ON m.family_id = p.family_id
+		WHERE m.user_id = ? AND p.role = ?`, ownerID, RoleParent)
+	if err != nil {
+		return nil, err
+	}
+	recipients := []string{ownerID}
+	for _, parent := range parents {
+		if parent != ownerID {
+			recipients = append(recipients, parent)
+		}
+	}
+	return recipients, nil
+}
+
+// 为每个能看到该任务的用户记录一条变更，返回各用户新的游标。调用方需持有changeMu
+func recordTaskChange(task *Task, changeType string) (map[string]int64, error) {
+	recipients, err := changeRecipients(task.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Format(timeLayout)
+	cursors := map[string]int64{}
+	for _, userID := range recipients {
+		var seq int64
+		err := tx.QueryRow(`INSERT INTO change_sequences (user_id, seq) VALUES (?, 1)
+			ON CONFLICT (user_id) DO UPDATE SET seq = seq + 1 RETURNING seq`, userID).Scan(&seq)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`INSERT INTO task_changes (user_id, seq, task_id, change_type, created_at) VALUES (?, ?, ?, ?, ?)`,
+			userID, seq, getTaskIDString(task), changeType, now); err != nil {
+			return nil, err
+		}
+		cursors[userID] = seq
+	}
+	return cursors, tx.Commit()
+}
+
+// 用户当前的游标，没有任何变更时为0
+func currentCursor(userID string) (int64, error) {
+	var seq int64
+	err := db.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM change_sequences WHERE user_id = ?", userID).Scan(&seq)
+	return seq, err
+}
+
+func currentCursors(ownerID string) (map[string]int64, error) {
+	recipients, err := changeRecipients(ownerID)
+	if err != nil {
+		return nil, err
+	}
+	cursors := map[string]int64{}
+	for _, userID := range recipients {
+		if cursors[userID], err = currentCursor(userID); err != nil {
+			return nil, err
+		}
+	}
+	return cursors, nil
+}
+
+// 查询userID在游标since之后的变更，同一任务只返回最后一次
+func changesSince(userID string, since int64, limit int) (*ChangesResponse, error) {
+	current, err := currentCursor(userID)
+	if err != nil {
+		return nil, err
+	}
+	response := &ChangesResponse{Changes: []Change{}, Cursor: since}
+	if since > current {
+		response.Reset = true
+		response.Cursor = current
+		return response, nil
+	}
+
+	rows, err := db.Query(`SELECT c.seq, c.task_id, c.change_type FROM task_changes c
+		WHERE c.user_id = ? AND c.seq > ?
+		AND c.seq = (SELECT MAX(seq) FROM task_changes WHERE user_id = c.user_id AND task_id = c.task_id)
+		ORDER BY c.seq LIMIT ?`, userID, since, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var change Change
+		if err := rows.Scan(&change.Seq, &change.TaskID, &change.Type); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		response.Changes = append(response.Changes, change)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(response.Changes) > limit {
+		response.Changes = response.Changes[:limit]
+		response.HasMore = true
+	}
+	if len(response.Changes) > 0 {
+		response.Cursor = response.Changes[len(response.Changes)-1].Seq
+	}
+	if !response.HasMore {
+		response.Cursor = current
+	}
+
+	for i := range response.Changes {
+		change := &response.Changes[i]
+		if change.Type != ChangeUpsert {
+			continue
+		}
+		task, err := taskService.GetTaskForUser(userID, change.TaskID)
+		if err == ErrTaskNotFound {
+			// 任务已经不存在或不再可见，按删除处理
+			change.Type = ChangeDelete
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		change.Task = task
+	}
+	return response, nil
+}
+
+// 解析since/limit参数
+func parseChangesQuery(sinceValue, limitValue string) (int64, int, error) {
+	var since int64
+	if sinceValue != "" {
+		var err error
+		if since, err = strconv.ParseInt(sinceValue, 10, 64); err != nil || since < 0 {
+			return 0, 0, ErrInvalidMessage
+		}
+	}
+	limit := 500
+	if l, err := strconv.Atoi(limitValue); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+	return since, limit, nil
+}
+
+// 增量同步REST处理器: GET /api/changes?since=N&limit=M
+func getChangesHandler(w http.ResponseWriter, r *http.Request) {
+	since, limit, err := parseChangesQuery(r.URL.Query().Get("since"), r.URL.Query().Get("limit"))
+	if err != nil {
+		http.Error(w, "无效的since参数", http.StatusBadRequest)
+		return
+	}
+
+	response, err := changesSince(userFromContext(r.Context()).ID, since, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// WebSocket消息 sync_since，data为 {since, limit}，只回复发送者一条changes消息
+func handleSyncSince(client *Client, request WSMessage) {
+	var since int64
+	limit := 500
+	if data, ok := request.Data.(map[string]interface{}); ok {
+		since = int64(getInt(data, "since"))
+		if l := getInt(data, "limit"); l > 0 && l <= 1000 {
+			limit = l
+		}
+	}
+
+	response, err := changesSince(client.userID, since, limit)
+	if err != nil {
+		client.reply(request, nil, err)
+		return
+	}
+	log.Printf("🔄 增量同步: user=%s, since=%d, 变更数=%d", client.userID, since, len(response.Changes))
+	client.conn.Send(WSMessage{Type: "changes", RequestID: request.RequestID, Data: response, Cursor: response.Cursor})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config 服务器运行配置，命令行参数优先，其次是环境变量
+type Config struct {
+	Addr   string // HTTP/WebSocket监听地址
+	DBPath string // SQLite数据库文件路径
+
+	TokenTTL       time.Duration // 登录token有效期
+	IdempotencyTTL time.Duration // 幂等键保留时间，过期后同一个键视为新请求
+	TrashRetention time.Duration // 回收站保留时间，超过后彻底删除，0表示永久保留
+	AllowedOrigins []string      // 允许建立WebSocket连接的浏览器来源，"*"表示全部
+	SendQueueSize  int           // 每个WebSocket连接的发送队列长度，溢出时断开要求重新同步
+	PingInterval   time.Duration // 服务器发送WebSocket ping的间隔
+	PongWait       time.Duration // 超过这个时间没有收到任何消息（包括pong）则断开
+	WriteWait      time.Duration // 单次写入的超时时间
+
+	ReminderInterval   time.Duration // 提醒扫描间隔，0表示关闭提醒
+	ReminderLookback   time.Duration // 停机期间错过的提醒在这个时间内补发
+	ReminderWebhookURL string        // 提醒到期时POST的外部webhook，可选
+}
+
+var config Config
+
+func envOrDefault(key, defaultValue string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return defaultValue
+}
+
+// 解析配置，返回剩余的非flag参数（子命令）
+func loadConfig(args []string) ([]string, error) {
+	fs := flag.NewFlagSet("websocket-server", flag.ContinueOnError)
+	fs.StringVar(&config.Addr, "addr", envOrDefault("ADDR", ":8082"), "监听地址")
+	fs.StringVar(&config.DBPath, "db", envOrDefault("DB_PATH", "./tasks.db"), "SQLite数据库文件路径")
+
+	fs.DurationVar(&config.TokenTTL, "token-ttl", 30*24*time.Hour, "登录token有效期")
+	fs.DurationVar(&config.IdempotencyTTL, "idempotency-ttl", 24*time.Hour, "幂等键保留时间")
+	trashDays := fs.Int("trash-retention-days", 30, "回收站保留天数，0表示永久保留")
+	allowedOrigins := fs.String("allowed-origins", os.Getenv("ALLOWED_ORIGINS"), "允许的WebSocket来源，逗号分隔")
+	fs.IntVar(&config.SendQueueSize, "ws-send-queue", 256, "每个WebSocket连接的发送队列长度")
+	fs.DurationVar(&config.PingInterval, "ws-ping-interval", 30*time.Second, "WebSocket ping间隔")
+	fs.DurationVar(&config.PongWait, "ws-pong-wait", 60*time.Second, "等待客户端响应的超时时间，需大于ping间隔")
+	fs.DurationVar(&config.WriteWait, "ws-write-wait", 10*time.Second, "WebSocket写超时")
+
+	fs.DurationVar(&config.ReminderInterval, "reminder-interval", 30*time.Second, "提醒扫描间隔，0表示关闭")
+	fs.DurationVar(&config.ReminderLookback, "reminder-lookback", time.Hour, "补发错过提醒的时间窗口")
+	fs.StringVar(&config.ReminderWebhookURL, "reminder-webhook", os.Getenv("REMINDER_WEBHOOK_URL"), "提醒webhook地址")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	config.TrashRetention = time.Duration(*trashDays) * 24 * time.Hour
+	if config.SendQueueSize < 1 {
+		config.SendQueueSize = 1
+	}
+	if config.PingInterval <= 0 || config.PongWait <= config.PingInterval || config.WriteWait <= 0 {
+		fmt.Fprintln(fs.Output(), "-ws-ping-interval和-ws-write-wait必须大于0，-ws-pong-wait必须大于-ws-ping-interval")
+		return nil, errors.New("无效的WebSocket心跳配置")
+	}
+
+	config.AllowedOrigins = nil
+	for _, origin := range strings.Split(*allowedOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			config.AllowedOrigins = append(config.AllowedOrigins, origin)
+		}
+	}
+	return fs.Args(), nil
+}
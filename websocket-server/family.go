@@ -0,0 +1,553 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// 家庭成员角色
+const (
+	RoleParent = "parent"
+	RoleChild  = "child"
+)
+
+var (
+	// ErrNotInFamily 用户还没有加入任何家庭
+	ErrNotInFamily = errors.New("尚未加入家庭")
+	// ErrAlreadyInFamily 每个用户只能属于一个家庭
+	ErrAlreadyInFamily = errors.New("用户已经属于一个家庭")
+	// ErrInvitationNotFound 邀请不存在或不是发给当前用户的
+	ErrInvitationNotFound = errors.New("邀请不存在")
+)
+
+// Family 家庭
+type Family struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	CreatedBy string         `json:"created_by"`
+	CreatedAt string         `json:"created_at"`
+	Members   []FamilyMember `json:"members"`
+}
+
+// FamilyMember 家庭成员
+type FamilyMember struct {
+	UserID      string `json:"user_id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	Role        string `json:"role"`
+	JoinedAt    string `json:"joined_at"`
+}
+
+// FamilyInvitation 家长发出的加入家庭邀请，被邀请的用户接受后才成为成员
+type FamilyInvitation struct {
+	ID         string `json:"id"`
+	FamilyID   string `json:"family_id"`
+	FamilyName string `json:"family_name"`
+	UserID     string `json:"user_id"`
+	Username   string `json:"username"`
+	Role       string `json:"role"`
+	InvitedBy  string `json:"invited_by"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// Membership 用户在家庭中的身份
+type Membership struct {
+	FamilyID string
+	Role     string
+}
+
+// 记录用户加入家庭，每个用户只能属于一个家庭
+func joinFamily(familyID, userID, role string) error {
+	if role != RoleParent && role != RoleChild {
+		return fmt.Errorf("无效的角色: %s", role)
+	}
+	membership, err := membershipOf(userID)
+	if err != nil {
+		return err
+	}
+	if membership != nil {
+		return ErrAlreadyInFamily
+	}
+	_, err = db.Exec("INSERT INTO family_members (family_id, user_id, role, joined_at) VALUES (?, ?, ?, ?)",
+		familyID, userID, role, time.Now().UTC().Format(timeLayout))
+	return err
+}
+
+// 查询用户的家庭身份，不属于任何家庭时返回nil, nil
+func membershipOf(userID string) (*Membership, error) {
+	var m Membership
+	err := db.QueryRow("SELECT family_id, role FROM family_members WHERE user_id = ? ORDER BY joined_at LIMIT 1", userID).
+		Scan(&m.FamilyID, &m.Role)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// 查询用户所属的所有家庭
+func familiesOfUser(userID string) ([]string, error) {
+	return queryStrings("SELECT family_id FROM family_members WHERE user_id = ?", userID)
+}
+
+// 查询家庭的所有成员
+func familyMembers(familyID string) ([]string, error) {
+	return queryStrings("SELECT user_id FROM family_members WHERE family_id = ? ORDER BY joined_at", familyID)
+}
+
+func queryStrings(query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// actorID是否是ownerID所在家庭的家长
+func isParentOf(actorID, ownerID string) (bool, error) {
+	var one int
+	err := db.QueryRow(`SELECT 1 FROM family_members p
+		JOIN family_members o ON o.family_id = p.family_id
+		WHERE p.user_id = ? AND p.role = ? AND o.user_id = ? LIMIT 1`,
+		actorID, RoleParent, ownerID).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// 用户是否是某个家庭中的孩子
+func isChild(userID string) (bool, error) {
+	var one int
+	err := db.QueryRow("SELECT 1 FROM family_members WHERE user_id = ? AND role = ? LIMIT 1", userID, RoleChild).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// 连接可以看到的所有用户：自己；家长还能看到家庭的其他成员
+func visibleUserIDs(userID, familyID, role string) []string {
+	userIDs := []string{userID}
+	if familyID == "" || role != RoleParent {
+		return userIDs
+	}
+
+	members, err := familyMembers(familyID)
+	if err != nil {
+		log.Printf("❌ 查询家庭成员失败: %v", err)
+		return userIDs
+	}
+	for _, member := range members {
+		if member != userID {
+			userIDs = append(userIDs, member)
+		}
+	}
+	return userIDs
+}
+
+func createFamily(name string, creator *User) (*Family, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("家庭名称不能为空")
+	}
+	suffix, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+
+	family := &Family{
+		ID:        "family_" + suffix,
+		Name:      name,
+		CreatedBy: creator.ID,
+		CreatedAt: time.Now().UTC().Format(timeLayout),
+	}
+	if membership, err := membershipOf(creator.ID); err != nil {
+		return nil, err
+	} else if membership != nil {
+		return nil, ErrAlreadyInFamily
+	}
+
+	_, err = db.Exec("INSERT INTO families (id, name, created_by, created_at) VALUES (?, ?, ?, ?)",
+		family.ID, family.Name, family.CreatedBy, family.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	// 创建者自动成为家长
+	if err := joinFamily(family.ID, creator.ID, RoleParent); err != nil {
+		return nil, err
+	}
+	return getFamily(family.ID)
+}
+
+func getFamily(familyID string) (*Family, error) {
+	var family Family
+	err := db.QueryRow("SELECT id, name, created_by, COALESCE(created_at, '') FROM families WHERE id = ?", familyID).
+		Scan(&family.ID, &family.Name, &family.CreatedBy, &family.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT m.user_id, COALESCE(u.username, ''), COALESCE(u.display_name, ''), m.role, COALESCE(m.joined_at, '')
+		FROM family_members m LEFT JOIN users u ON u.id = m.user_id
+		WHERE m.family_id = ? ORDER BY m.joined_at`, familyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	family.Members = []FamilyMember{}
+	for rows.Next() {
+		var member FamilyMember
+		if err := rows.Scan(&member.UserID, &member.Username, &member.DisplayName, &member.Role, &member.JoinedAt); err != nil {
+			return nil, err
+		}
+		family.Members = append(family.Members, member)
+	}
+	return &family, rows.Err()
+}
+
+// 当前用户必须是家长，返回其家庭身份
+func requireParentMembership(w http.ResponseWriter, user *User) *Membership {
+	membership, err := membershipOf(user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	if membership == nil {
+		http.Error(w, ErrNotInFamily.Error(), http.StatusNotFound)
+		return nil
+	}
+	if membership.Role != RoleParent {
+		http.Error(w, ErrForbidden.Error(), http.StatusForbidden)
+		return nil
+	}
+	return membership
+}
+
+// 家庭相关REST处理器
+func createFamilyHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	family, err := createFamily(req.Name, userFromContext(r.Context()))
+	if err != nil {
+		if err == ErrAlreadyInFamily {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("👨‍👩‍👧 新家庭创建: %s (%s)", family.Name, family.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(family)
+}
+
+func getFamilyHandler(w http.ResponseWriter, r *http.Request) {
+	membership, err := membershipOf(userFromContext(r.Context()).ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if membership == nil {
+		http.Error(w, ErrNotInFamily.Error(), http.StatusNotFound)
+		return
+	}
+
+	family, err := getFamily(membership.FamilyID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(family)
+}
+
+// 家长为孩子创建账号并加入家庭
+func createChildHandler(w http.ResponseWriter, r *http.Request) {
+	membership := requireParentMembership(w, userFromContext(r.Context()))
+	if membership == nil {
+		return
+	}
+
+	var req struct {
+		Username    string `json:"username"`
+		Password    string `json:"password"`
+		DisplayName string `json:"display_name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	child, err := createUser(req.Username, req.Password, req.DisplayName)
+	if err != nil {
+		if err == ErrUsernameTaken {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := joinFamily(membership.FamilyID, child.ID, RoleChild); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("👶 家庭 %s 新增孩子账号: %s", membership.FamilyID, child.Username)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(child)
+}
+
+const invitationQuery = `SELECT i.id, i.family_id, COALESCE(f.name, ''), i.user_id, COALESCE(u.username, ''),
+	i.role, i.invited_by, COALESCE(i.created_at, '')
+	FROM family_invitations i LEFT JOIN families f ON f.id = i.family_id LEFT JOIN users u ON u.id = i.user_id`
+
+func queryInvitations(where string, args ...interface{}) ([]FamilyInvitation, error) {
+	rows, err := db.Query(invitationQuery+" WHERE "+where+" ORDER BY i.created_at", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []FamilyInvitation{}
+	for rows.Next() {
+		var inv FamilyInvitation
+		if err := rows.Scan(&inv.ID, &inv.FamilyID, &inv.FamilyName, &inv.UserID, &inv.Username,
+			&inv.Role, &inv.InvitedBy, &inv.CreatedAt); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
+
+func getInvitation(id string) (*FamilyInvitation, error) {
+	invitations, err := queryInvitations("i.id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(invitations) == 0 {
+		return nil, ErrInvitationNotFound
+	}
+	return &invitations[0], nil
+}
+
+// 家长邀请已有账号加入家庭（例如另一位家长），对方接受之前不能查看或管理对方的任务
+func addFamilyMemberHandler(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	membership := requireParentMembership(w, user)
+	if membership == nil {
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Role     string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = RoleChild
+	}
+	if req.Role != RoleParent && req.Role != RoleChild {
+		http.Error(w, fmt.Sprintf("无效的角色: %s", req.Role), http.StatusBadRequest)
+		return
+	}
+
+	var userID string
+	err := db.QueryRow("SELECT id FROM users WHERE username = ?", strings.TrimSpace(req.Username)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "用户不存在", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if existing, err := membershipOf(userID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if existing != nil {
+		http.Error(w, ErrAlreadyInFamily.Error(), http.StatusConflict)
+		return
+	}
+
+	suffix, err := randomHex(8)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// 重复邀请同一个用户时更新角色
+	_, err = db.Exec(`INSERT INTO family_invitations (id, family_id, user_id, role, invited_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (family_id, user_id) DO UPDATE SET role = excluded.role, invited_by = excluded.invited_by`,
+		"invite_"+suffix, membership.FamilyID, userID, req.Role, user.ID, time.Now().UTC().Format(timeLayout))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	invitations, err := queryInvitations("i.family_id = ? AND i.user_id = ?", membership.FamilyID, userID)
+	if err != nil || len(invitations) == 0 {
+		http.Error(w, "保存邀请失败", http.StatusInternalServerError)
+		return
+	}
+	invitation := invitations[0]
+
+	log.Printf("✉️ 家庭 %s 邀请 %s 加入(%s)", membership.FamilyID, invitation.Username, invitation.Role)
+	sendToUser(userID, WSMessage{Type: "family_invitation", Data: invitation})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(invitation)
+}
+
+// GET /api/family/invitations: 发给当前用户的邀请；家长还能看到本家庭发出、尚未接受的邀请
+func getInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	membership, err := membershipOf(user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	familyID := ""
+	if membership != nil && membership.Role == RoleParent {
+		familyID = membership.FamilyID
+	}
+	invitations, err := queryInvitations("i.user_id = ? OR i.family_id = ?", user.ID, familyID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// POST /api/family/invitations/{id}/accept: 被邀请的用户接受后加入家庭，其他邀请随之作废
+func acceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	invitation, err := getInvitation(mux.Vars(r)["id"])
+	if err == nil && invitation.UserID != user.ID {
+		err = ErrInvitationNotFound
+	}
+	if err != nil {
+		writeInvitationError(w, err)
+		return
+	}
+
+	if err := joinFamily(invitation.FamilyID, user.ID, invitation.Role); err != nil {
+		writeInvitationError(w, err)
+		return
+	}
+	if _, err := db.Exec("DELETE FROM family_invitations WHERE user_id = ?", user.ID); err != nil {
+		log.Printf("❌ 清理邀请失败: %v", err)
+	}
+	log.Printf("👨‍👩‍👧 %s 接受邀请加入家庭 %s(%s)", user.Username, invitation.FamilyID, invitation.Role)
+
+	family, err := getFamily(invitation.FamilyID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(family)
+}
+
+// DELETE /api/family/invitations/{id}: 被邀请的用户拒绝，或发出邀请的家庭中的家长撤回
+func deleteInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	invitation, err := getInvitation(mux.Vars(r)["id"])
+	if err != nil {
+		writeInvitationError(w, err)
+		return
+	}
+	if invitation.UserID != user.ID {
+		membership, err := membershipOf(user.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if membership == nil || membership.Role != RoleParent || membership.FamilyID != invitation.FamilyID {
+			writeInvitationError(w, ErrInvitationNotFound)
+			return
+		}
+	}
+
+	if _, err := db.Exec("DELETE FROM family_invitations WHERE id = ?", invitation.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "邀请已取消"})
+}
+
+func writeInvitationError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrInvitationNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case ErrAlreadyInFamily:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func removeFamilyMemberHandler(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	membership := requireParentMembership(w, user)
+	if membership == nil {
+		return
+	}
+
+	userID := mux.Vars(r)["user_id"]
+	if userID == user.ID {
+		http.Error(w, "不能移除自己", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM family_members WHERE family_id = ? AND user_id = ?", membership.FamilyID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		http.Error(w, "不是该家庭的成员", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "成员已移除"})
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrInvalidFilter 筛选表达式语法错误
+var ErrInvalidFilter = errors.New("无效的筛选表达式")
+
+// 表达式的长度和括号嵌套限制
+const (
+	maxFilterLength = 500
+	maxFilterDepth  = 16
+)
+
+// 筛选表达式，例如:
+//
+//	category:学习 priority>=2 due<today+7d !done
+//	(category:学习 OR category:运动) -is:recurring "期中 考试"
+//
+// 空格分隔的条件同时满足；OR或|连接的条件满足其一；!、-或NOT取反；括号分组。
+// 条件为 字段+运算符+值，运算符有 : = != < <= > >=；
+// 不带字段的词在标题和描述中搜索，done/overdue/recurring/pending是is:的简写。
+// 日期值可以是 today、tomorrow、yesterday、now，加减 h/d/w/m（如 today+7d），或具体日期
+type filterNode interface {
+	compile(c *filterCompiler) (string, error)
+}
+
+type filterAnd []filterNode
+type filterOr []filterNode
+type filterNot struct{ node filterNode }
+type filterTerm struct {
+	field string
+	op    string
+	value string
+}
+
+func invalidFilter(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidFilter, fmt.Sprintf(format, args...))
+}
+
+// 词法单元: ( ) NOT OR 和条件
+type filterToken struct {
+	kind string // "(", ")", "not", "or", "term"
+	term filterTerm
+}
+
+var filterOperators = []string{">=", "<=", "!=", ":", "=", "<", ">"}
+
+func isFieldRune(r rune) bool {
+	return r == '_' || (r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)))
+}
+
+func lexFilter(input string) ([]filterToken, error) {
+	runes := []rune(input)
+	var tokens []filterToken
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '(' || r == ')':
+			tokens = append(tokens, filterToken{kind: string(r)})
+			i++
+			continue
+		case r == '|':
+			tokens = append(tokens, filterToken{kind: "or"})
+			i++
+			continue
+		case r == '!' || (r == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1])):
+			tokens = append(tokens, filterToken{kind: "not"})
+			i++
+			continue
+		}
+
+		// 读取一个条件，直到空白或括号，引号内的内容原样保留
+		var raw strings.Builder
+		quoted := runes[i] == '"'
+		for i < len(runes) {
+			r := runes[i]
+			if r == '"' {
+				end := i + 1
+				for end < len(runes) && runes[end] != '"' {
+					end++
+				}
+				if end == len(runes) {
+					return nil, invalidFilter("缺少结束引号")
+				}
+				raw.WriteString(string(runes[i+1 : end]))
+				i = end + 1
+				continue
+			}
+			if unicode.IsSpace(r) || r == '(' || r == ')' {
+				break
+			}
+			raw.WriteRune(r)
+			i++
+		}
+		word := raw.String()
+
+		if !quoted && (word == "OR" || word == "AND" || word == "NOT") {
+			switch word {
+			case "OR":
+				tokens = append(tokens, filterToken{kind: "or"})
+			case "NOT":
+				tokens = append(tokens, filterToken{kind: "not"})
+			}
+			continue
+		}
+		tokens = append(tokens, filterToken{kind: "term", term: parseFilterTerm(word, quoted)})
+	}
+	return tokens, nil
+}
+
+// field op value；没有字段时是全文条件。以引号开头的词不会被当作字段
+func parseFilterTerm(word string, quoted bool) filterTerm {
+	if quoted {
+		return filterTerm{field: "text", op: ":", value: word}
+	}
+	end := 0
+	for _, r := range word {
+		if !isFieldRune(r) {
+			break
+		}
+		end += len(string(r))
+	}
+	if end > 0 && end < len(word) {
+		rest := word[end:]
+		for _, op := range filterOperators {
+			if strings.HasPrefix(rest, op) {
+				return filterTerm{field: strings.ToLower(word[:end]), op: op, value: rest[len(op):]}
+			}
+		}
+	}
+	switch strings.ToLower(word) {
+	case "done", "completed", "overdue", "recurring", "pending":
+		return filterTerm{field: "is", op: ":", value: strings.ToLower(word)}
+	}
+	return filterTerm{field: "text", op: ":", value: word}
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+	depth  int
+}
+
+// parseFilter 解析筛选表达式，空表达式返回nil
+func parseFilter(input string) (filterNode, error) {
+	if len([]rune(input)) > maxFilterLength {
+		return nil, invalidFilter("表达式不能超过%d个字符", maxFilterLength)
+	}
+	tokens, err := lexFilter(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	p := &filterParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, invalidFilter("多余的%q", p.tokens[p.pos].kind)
+	}
+	return node, nil
+}
+
+func (p *filterParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos].kind
+	}
+	return ""
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	node, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	or := filterOr{node}
+	for p.peek() == "or" {
+		p.pos++
+		if node, err = p.parseAnd(); err != nil {
+			return nil, err
+		}
+		or = append(or, node)
+	}
+	if len(or) == 1 {
+		return or[0], nil
+	}
+	return or, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	var and filterAnd
+	for {
+		switch p.peek() {
+		case "", ")", "or":
+			if len(and) == 0 {
+				return nil, invalidFilter("缺少条件")
+			}
+			if len(and) == 1 {
+				return and[0], nil
+			}
+			return and, nil
+		}
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, node)
+	}
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	token := p.tokens[p.pos]
+	p.pos++
+	switch token.kind {
+	case "not":
+		if p.peek() == "" {
+			return nil, invalidFilter("取反后缺少条件")
+		}
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return filterNot{node}, nil
+	case "(":
+		if p.depth++; p.depth > maxFilterDepth {
+			return nil, invalidFilter("括号嵌套过深")
+		}
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, invalidFilter("缺少右括号")
+		}
+		p.pos++
+		p.depth--
+		return node, nil
+	case "term":
+		return token.term, nil
+	default:
+		return nil, invalidFilter("意外的%q", token.kind)
+	}
+}
+
+// 编译为SQL条件，时间相关的值按loc中的now计算
+type filterCompiler struct {
+	actorID string
+	now     time.Time
+	loc     *time.Location
+	args    []interface{}
+}
+
+func (c *filterCompiler) arg(value interface{}) string {
+	c.args = append(c.args, value)
+	return "?"
+}
+
+func (n filterAnd) compile(c *filterCompiler) (string, error) { return compileJoin(c, n, " AND ") }
+func (n filterOr) compile(c *filterCompiler) (string, error)  { return compileJoin(c, n, " OR ") }
+
+func compileJoin(c *filterCompiler, nodes []filterNode, separator string) (string, error) {
+	parts := make([]string, len(nodes))
+	for i, node := range nodes {
+		sql, err := node.compile(c)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = sql
+	}
+	return "(" + strings.Join(parts, separator) + ")", nil
+}
+
+func (n filterNot) compile(c *filterCompiler) (string, error) {
+	sql, err := n.node.compile(c)
+	if err != nil {
+		return "", err
+	}
+	return "(NOT " + sql + ")", nil
+}
+
+// 每个条件都用COALESCE包成0/1，字段为空时取反也能得到预期结果
+func (t filterTerm) compile(c *filterCompiler) (string, error) {
+	sql, err := t.condition(c)
+	if err != nil {
+		return "", err
+	}
+	return "COALESCE(" + sql + ", 0)", nil
+}
+
+var filterDateColumns = map[string]string{
+	"due":     "due_date",
+	"start":   "start_date",
+	"created": "created_at",
+	"updated": "updated_at",
+}
+
+var filterIntColumns = map[string]string{
+	"priority": "priority",
+	"points":   "points",
+}
+
+func likePattern(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	return "%" + replacer.Replace(value) + "%"
+}
+
+func (t filterTerm) condition(c *filterCompiler) (string, error) {
+	if column, ok := filterDateColumns[t.field]; ok {
+		return c.dateCondition(column, t)
+	}
+	if column, ok := filterIntColumns[t.field]; ok {
+		return c.intCondition(column, t)
+	}
+
+	switch t.field {
+	case "text":
+		pattern := c.arg(likePattern(t.value))
+		return "(title LIKE " + pattern + " ESCAPE '\\' OR description LIKE " + c.arg(likePattern(t.value)) + " ESCAPE '\\')", nil
+	case "title", "description", "desc":
+		column := "title"
+		if t.field != "title" {
+			column = "description"
+		}
+		if t.op != ":" {
+			return c.stringCondition(column, t)
+		}
+		return column + " LIKE " + c.arg(likePattern(t.value)) + " ESCAPE '\\'", nil
+	case "category", "cat":
+		return c.stringCondition("category", t)
+	case "device":
+		return c.stringCondition("device_id", t)
+	case "status", "approval":
+		return c.stringCondition("approval_status", t)
+	case "user", "assignee":
+		if t.value == "me" {
+			t.value = c.actorID
+		}
+		return c.stringCondition("user_id", t)
+	case "is":
+		return c.isCondition(t)
+	case "has":
+		return c.hasCondition(t)
+	}
+	return "", invalidFilter("未知字段%q", t.field)
+}
+
+// 字符串字段只支持 : = !=，:和=可以用逗号给出多个值
+func (c *filterCompiler) stringCondition(column string, t filterTerm) (string, error) {
+	switch t.op {
+	case ":", "=", "!=":
+	default:
+		return "", invalidFilter("%s不支持%s", t.field, t.op)
+	}
+	values := strings.Split(t.value, ",")
+	placeholders := make([]string, len(values))
+	for i, value := range values {
+		placeholders[i] = c.arg(value)
+	}
+	in := " IN (" + strings.Join(placeholders, ", ") + ")"
+	if t.op == "!=" {
+		return "COALESCE(" + column + ", '') NOT" + in, nil
+	}
+	return column + in, nil
+}
+
+func (c *filterCompiler) intCondition(column string, t filterTerm) (string, error) {
+	values := strings.Split(t.value, ",")
+	numbers := make([]int, len(values))
+	for i, value := range values {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return "", invalidFilter("%s需要整数: %s", t.field, value)
+		}
+		numbers[i] = n
+	}
+	expr := "COALESCE(" + column + ", 0)"
+	if len(numbers) > 1 {
+		if t.op != ":" && t.op != "=" {
+			return "", invalidFilter("%s%s只能比较一个值", t.field, t.op)
+		}
+		placeholders := make([]string, len(numbers))
+		for i, n := range numbers {
+			placeholders[i] = c.arg(n)
+		}
+		return expr + " IN (" + strings.Join(placeholders, ", ") + ")", nil
+	}
+	op := t.op
+	if op == ":" {
+		op = "="
+	}
+	return expr + " " + op + " " + c.arg(numbers[0]), nil
+}
+
+// 日期值: 解析为[from, to)区间，只精确到天的值（today、日期）覆盖整天
+func (c *filterCompiler) dateRange(value string) (time.Time, time.Time, error) {
+	lower := strings.ToLower(value)
+	base, rest := lower, ""
+	for _, keyword := range []string{"today", "tomorrow", "yesterday", "now"} {
+		if strings.HasPrefix(lower, keyword) {
+			base, rest = keyword, lower[len(keyword):]
+			break
+		}
+	}
+
+	now := c.now.In(c.loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, c.loc)
+	var start time.Time
+	wholeDay := true
+	switch base {
+	case "today":
+		start = today
+	case "tomorrow":
+		start = today.AddDate(0, 0, 1)
+	case "yesterday":
+		start = today.AddDate(0, 0, -1)
+	case "now":
+		start = now
+		wholeDay = false
+	default:
+		t, err := time.ParseInLocation("2006-01-02", value, c.loc)
+		if err != nil {
+			parsed, ok := parseTaskTime(value)
+			if !ok {
+				return time.Time{}, time.Time{}, invalidFilter("无效的日期%q", value)
+			}
+			return parsed, parsed, nil
+		}
+		return t, t.AddDate(0, 0, 1), nil
+	}
+
+	if rest != "" {
+		if len(rest) < 3 || (rest[0] != '+' && rest[0] != '-') {
+			return time.Time{}, time.Time{}, invalidFilter("无效的日期%q", value)
+		}
+		n, err := strconv.Atoi(rest[1 : len(rest)-1])
+		if err != nil {
+			return time.Time{}, time.Time{}, invalidFilter("无效的日期%q", value)
+		}
+		if rest[0] == '-' {
+			n = -n
+		}
+		switch rest[len(rest)-1] {
+		case 'h':
+			start = start.Add(time.Duration(n) * time.Hour)
+			wholeDay = false
+		case 'd':
+			start = start.AddDate(0, 0, n)
+		case 'w':
+			start = start.AddDate(0, 0, 7*n)
+		case 'm':
+			start = start.AddDate(0, n, 0)
+		default:
+			return time.Time{}, time.Time{}, invalidFilter("无效的时间单位%q", value)
+		}
+	}
+	if !wholeDay {
+		return start, start, nil
+	}
+	return start, start.AddDate(0, 0, 1), nil
+}
+
+func (c *filterCompiler) dateCondition(column string, t filterTerm) (string, error) {
+	from, to, err := c.dateRange(t.value)
+	if err != nil {
+		return "", err
+	}
+	// 参数按占位符出现的顺序加入，只在用到时调用
+	expr := "datetime(" + column + ")"
+	start := func() string { return c.arg(from.UTC().Format(timeLayout)) }
+	end := func() string { return c.arg(to.UTC().Format(timeLayout)) }
+	if !to.After(from) {
+		// 精确时间
+		op := t.op
+		if op == ":" {
+			op = "="
+		}
+		return expr + " " + op + " " + start(), nil
+	}
+
+	switch t.op {
+	case ":", "=":
+		return "(" + expr + " >= " + start() + " AND " + expr + " < " + end() + ")", nil
+	case "!=":
+		return "(" + expr + " < " + start() + " OR " + expr + " >= " + end() + ")", nil
+	case "<":
+		return expr + " < " + start(), nil
+	case ">=":
+		return expr + " >= " + start(), nil
+	case "<=":
+		return expr + " < " + end(), nil
+	default: // >
+		return expr + " >= " + end(), nil
+	}
+}
+
+func (c *filterCompiler) isCondition(t filterTerm) (string, error) {
+	if t.op != ":" && t.op != "=" {
+		return "", invalidFilter("is不支持%s", t.op)
+	}
+	switch strings.ToLower(t.value) {
+	case "done", "completed":
+		return "is_completed = 1", nil
+	case "open", "todo":
+		return "COALESCE(is_completed, 0) = 0", nil
+	case "overdue":
+		return "(COALESCE(is_completed, 0) = 0 AND datetime(due_date) < " + c.arg(c.now.UTC().Format(timeLayout)) + ")", nil
+	case "recurring":
+		return "COALESCE(rrule, '') != ''", nil
+	case ApprovalPending, ApprovalApproved, ApprovalRejected:
+		return "approval_status = " + c.arg(strings.ToLower(t.value)), nil
+	}
+	return "", invalidFilter("未知的is:%s", t.value)
+}
+
+func (c *filterCompiler) hasCondition(t filterTerm) (string, error) {
+	if t.op != ":" && t.op != "=" {
+		return "", invalidFilter("has不支持%s", t.op)
+	}
+	columns := map[string]string{
+		"due":         "due_date",
+		"start":       "start_date",
+		"description": "description",
+		"reminder":    "reminder_offsets",
+		"rrule":       "rrule",
+	}
+	value := strings.ToLower(t.value)
+	if value == "points" {
+		return "COALESCE(points, 0) > 0", nil
+	}
+	column, ok := columns[value]
+	if !ok {
+		return "", invalidFilter("未知的has:%s", t.value)
+	}
+	return "COALESCE(" + column + ", '') != ''", nil
+}
+
+// compileFilter 把筛选表达式编译为参数化的SQL条件，空表达式返回空字符串
+func compileFilter(expression, actorID string, now time.Time, loc *time.Location) (string, []interface{}, error) {
+	node, err := parseFilter(expression)
+	if err != nil || node == nil {
+		return "", nil, err
+	}
+	c := &filterCompiler{actorID: actorID, now: now, loc: loc}
+	sql, err := node.compile(c)
+	if err != nil {
+		return "", nil, err
+	}
+	return sql, c.args, nil
+}
+
+// 时区: IANA名称（Asia/Shanghai）或偏移（+08:00），为空时使用UTC
+func parseTimeZone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	if t, err := time.Parse("-07:00", name); err == nil {
+		_, offset := t.Zone()
+		return time.FixedZone(name, offset), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, invalidFilter("未知时区%q", name)
+	}
+	return loc, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 把语法树输出为便于比较的前缀形式
+func formatFilterNode(node filterNode) string {
+	join := func(name string, nodes []filterNode) string {
+		parts := make([]string, len(nodes))
+		for i, child := range nodes {
+			parts[i] = formatFilterNode(child)
+		}
+		return name + "(" + strings.Join(parts, " ") + ")"
+	}
+	switch n := node.(type) {
+	case filterAnd:
+		return join("and", n)
+	case filterOr:
+		return join("or", n)
+	case filterNot:
+		return "not(" + formatFilterNode(n.node) + ")"
+	case filterTerm:
+		return n.field + n.op + n.value
+	}
+	return "?"
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		input, want string
+	}{
+		{"category:学习", "category:学习"},
+		{"category:学习 priority>=2", "and(category:学习 priority>=2)"},
+		{"Priority!=1", "priority!=1"},
+		{"a OR b c", "or(text:a and(text:b text:c))"},
+		{"a | b", "or(text:a text:b)"},
+		{"a AND b", "and(text:a text:b)"},
+		{"(a OR b) c", "and(or(text:a text:b) text:c)"},
+		{"!done", "not(is:done)"},
+		{"-is:recurring", "not(is:recurring)"},
+		{"NOT (a b)", "not(and(text:a text:b))"},
+		{"!!a", "not(not(text:a))"},
+		{"due<today+7d", "due<today+7d"},
+		{`"期中 考试"`, "text:期中 考试"},
+		{`"due:today"`, "text:due:today"},
+		{`title:"数学 作业"`, "title:数学 作业"},
+		{"a - b", "and(text:a text:- text:b)"},
+		{"overdue pending", "and(is:overdue is:pending)"},
+		{"数学:作业", "text:数学:作业"},
+		{"   ", ""},
+	}
+	for _, tt := range tests {
+		node, err := parseFilter(tt.input)
+		if err != nil {
+			t.Errorf("parseFilter(%q) 出错: %v", tt.input, err)
+			continue
+		}
+		got := ""
+		if node != nil {
+			got = formatFilterNode(node)
+		}
+		if got != tt.want {
+			t.Errorf("parseFilter(%q) = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, input := range []string{
+		"(a",
+		"a)",
+		"()",
+		"a OR",
+		"OR a",
+		"!",
+		`"unterminated`,
+		strings.Repeat("(", maxFilterDepth+1) + "a" + strings.Repeat(")", maxFilterDepth+1),
+		strings.Repeat("a", maxFilterLength+1),
+	} {
+		if _, err := parseFilter(input); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("parseFilter(%q) = %v, want ErrInvalidFilter", input, err)
+		}
+	}
+}
+
+func TestCompileFilterErrors(t *testing.T) {
+	for _, input := range []string{
+		"colour:red",
+		"priority:high",
+		"priority>1,2",
+		"category<学习",
+		"is:later",
+		"is!=done",
+		"has:photo",
+		"due:someday",
+		"due<today+3x",
+		"due<today+d",
+	} {
+		if _, _, err := compileFilter(input, "u1", time.Now(), time.UTC); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("compileFilter(%q) = %v, want ErrInvalidFilter", input, err)
+		}
+	}
+}
+
+func TestParseTimeZone(t *testing.T) {
+	loc, err := parseTimeZone("+08:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, offset := time.Date(2026, 1, 1, 0, 0, 0, 0, loc).Zone(); offset != 8*3600 {
+		t.Errorf("+08:00的偏移 = %d", offset)
+	}
+	if loc, err := parseTimeZone(""); err != nil || loc != time.UTC {
+		t.Errorf("空时区应该是UTC: %v, %v", loc, err)
+	}
+	if _, err := parseTimeZone("Mars/Base"); !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("未知时区 = %v", err)
+	}
+}
+
+// 在真实的任务上执行编译后的条件
+func TestCompileFilterMatches(t *testing.T) {
+	openTestDB(t)
+	utc8 := time.FixedZone("UTC+8", 8*3600)
+	now := time.Date(2026, 10, 17, 10, 0, 0, 0, utc8)
+
+	for _, task := range []Task{
+		{Title: "数学作业", Description: "练习册", Category: "学习", Priority: 2, DueDate: "2026-10-17T20:00:00+08:00", Points: 5},
+		{Title: "跑步", Category: "运动", Priority: 1, DueDate: "2026-10-16T08:00:00+08:00", ReminderOffsets: "10"},
+		{Title: "练琴", Category: "学习", Priority: 3, DueDate: "2026-10-20T08:00:00+08:00", RRule: "FREQ=DAILY"},
+		{Title: "50%_完成", Description: "没有截止时间", IsCompleted: true},
+		// 东八区10-18凌晨，按UTC是10-17
+		{Title: "早起", Category: "生活", DueDate: "2026-10-18T01:00:00+08:00"},
+	} {
+		createTestTask(t, "solo", task)
+	}
+
+	tests := []struct {
+		filter, want string
+	}{
+		{"category:学习", "数学作业,练琴"},
+		{"cat:学习,运动", "数学作业,跑步,练琴"},
+		{"category!=学习", "跑步,50%_完成,早起"},
+		{"priority>=2", "数学作业,练琴"},
+		{"priority:1,3", "跑步,练琴"},
+		{"due:today", "数学作业"},
+		{"due:tomorrow", "早起"},
+		{"due<today", "跑步"},
+		{"due<=tomorrow", "数学作业,跑步,早起"},
+		{"due>tomorrow", "练琴"},
+		{"due:2026-10-20", "练琴"},
+		{"due<now+12h", "数学作业,跑步"},
+		{"due!=today has:due", "跑步,练琴,早起"},
+		{"overdue", "跑步"},
+		{"done", "50%_完成"},
+		{"is:open -has:due", ""},
+		{"recurring", "练琴"},
+		{"has:reminder", "跑步"},
+		{"has:points", "数学作业"},
+		{"has:description", "数学作业,50%_完成"},
+		{"数学", "数学作业"},
+		{"练习册", "数学作业"},
+		{"title:练", "练琴"},
+		// LIKE的通配符按字面匹配
+		{"50%_", "50%_完成"},
+		{"%", "50%_完成"},
+		{"user:me", "数学作业,跑步,练琴,50%_完成,早起"},
+		{"user:other", ""},
+		{"(category:学习 OR category:运动) !recurring", "数学作业,跑步"},
+		{"NOT category:学习 NOT done", "跑步,早起"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			q := TaskQuery{OwnerIDs: []string{"solo"}, Filter: tt.filter, TimeZone: utc8,
+				Sort: []taskSortKey{{Field: "created_at"}}}
+			if err := q.compileFilter("solo", now); err != nil {
+				t.Fatal(err)
+			}
+			tasks, _, err := taskService.store.Query(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var titles []string
+			for i := range tasks {
+				titles = append(titles, tasks[i].Title)
+			}
+			if got := strings.Join(titles, ","); got != tt.want {
+				t.Errorf("筛选%q = %s, want %s", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
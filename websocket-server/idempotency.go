@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrIdempotencyMismatch 同一个幂等键被用于不同的请求
+	ErrIdempotencyMismatch = errors.New("幂等键已用于其他请求")
+	// ErrIdempotencyInProgress 使用该幂等键的请求仍在处理中
+	ErrIdempotencyInProgress = errors.New("相同幂等键的请求正在处理")
+)
+
+// 幂等记录的状态码0表示请求已占用该键但尚未完成
+const idempotencyPending = 0
+
+// 保存的第一次执行结果
+type idempotentResult struct {
+	StatusCode  int
+	ContentType string
+	Response    string
+}
+
+type idempotencyScope struct {
+	UserID   string
+	DeviceID string
+	Key      string
+}
+
+// 请求指纹，用于发现同一个幂等键被用于不同的请求
+func requestFingerprint(parts ...[]byte) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write(part)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// 占用幂等键。键已完成时返回第一次的结果；第一次使用时返回nil，调用方执行后需要completeIdempotencyKey或releaseIdempotencyKey
+func reserveIdempotencyKey(scope idempotencyScope, fingerprint string) (*idempotentResult, error) {
+	expired := time.Now().UTC().Add(-config.IdempotencyTTL).Format(timeLayout)
+	if _, err := db.Exec(`DELETE FROM idempotency_keys WHERE user_id = ? AND device_id = ? AND idempotency_key = ? AND created_at < ?`,
+		scope.UserID, scope.DeviceID, scope.Key, expired); err != nil {
+		return nil, err
+	}
+
+	result, err := db.Exec(`INSERT OR IGNORE INTO idempotency_keys (user_id, device_id, idempotency_key, fingerprint, status_code, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		scope.UserID, scope.DeviceID, scope.Key, fingerprint, idempotencyPending, time.Now().UTC().Format(timeLayout))
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 1 {
+		return nil, nil
+	}
+
+	var storedFingerprint string
+	stored := &idempotentResult{}
+	err = db.QueryRow(`SELECT fingerprint, status_code, COALESCE(content_type, ''), COALESCE(response, '')
+		FROM idempotency_keys WHERE user_id = ? AND device_id = ? AND idempotency_key = ?`,
+		scope.UserID, scope.DeviceID, scope.Key).Scan(&storedFingerprint, &stored.StatusCode, &stored.ContentType, &stored.Response)
+	if err == sql.ErrNoRows {
+		// 刚好被清理，按第一次请求处理
+		return reserveIdempotencyKey(scope, fingerprint)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if storedFingerprint != fingerprint {
+		return nil, ErrIdempotencyMismatch
+	}
+	if stored.StatusCode == idempotencyPending {
+		return nil, ErrIdempotencyInProgress
+	}
+	return stored, nil
+}
+
+func completeIdempotencyKey(scope idempotencyScope, result *idempotentResult) {
+	_, err := db.Exec(`UPDATE idempotency_keys SET status_code = ?, content_type = ?, response = ?
+		WHERE user_id = ? AND device_id = ? AND idempotency_key = ?`,
+		result.StatusCode, result.ContentType, result.Response, scope.UserID, scope.DeviceID, scope.Key)
+	if err != nil {
+		log.Printf("❌ 保存幂等结果失败: %v", err)
+	}
+}
+
+// 服务器内部错误不保存结果，释放幂等键让客户端可以重试
+func releaseIdempotencyKey(scope idempotencyScope) {
+	_, err := db.Exec(`DELETE FROM idempotency_keys WHERE user_id = ? AND device_id = ? AND idempotency_key = ?`,
+		scope.UserID, scope.DeviceID, scope.Key)
+	if err != nil {
+		log.Printf("❌ 释放幂等键失败: %v", err)
+	}
+}
+
+// 定期清理超过保留期的幂等记录
+func purgeIdempotencyKeys() {
+	for {
+		expired := time.Now().UTC().Add(-config.IdempotencyTTL).Format(timeLayout)
+		if result, err := db.Exec("DELETE FROM idempotency_keys WHERE created_at < ?", expired); err != nil {
+			log.Printf("❌ 清理幂等记录失败: %v", err)
+		} else if n, _ := result.RowsAffected(); n > 0 {
+			log.Printf("🧹 清理过期幂等记录: %d 条", n)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// 记录处理器写出的响应
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// REST幂等中间件：带Idempotency-Key头的修改请求只执行一次，
+// 之后同一设备(X-Device-ID)的重试直接返回第一次的响应，并带上Idempotent-Replayed头
+func idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method == http.MethodGet || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := idempotencyScope{
+			UserID:   userFromContext(r.Context()).ID,
+			DeviceID: r.Header.Get("X-Device-ID"),
+			Key:      key,
+		}
+		stored, err := reserveIdempotencyKey(scope, requestFingerprint([]byte(r.Method), []byte(r.URL.RequestURI()), body))
+		switch err {
+		case nil:
+		case ErrIdempotencyMismatch:
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case ErrIdempotencyInProgress:
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if stored != nil {
+			log.Printf("♻️ 重放幂等请求: %s %s key=%s", r.Method, r.URL.Path, key)
+			if stored.ContentType != "" {
+				w.Header().Set("Content-Type", stored.ContentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.StatusCode)
+			io.WriteString(w, stored.Response)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		if recorder.status >= 500 {
+			releaseIdempotencyKey(scope)
+			return
+		}
+		completeIdempotencyKey(scope, &idempotentResult{
+			StatusCode:  recorder.status,
+			ContentType: w.Header().Get("Content-Type"),
+			Response:    recorder.body.String(),
+		})
+	})
+}
+
+// WebSocket消息的幂等处理：使用idempotency_key（没有时使用request_id），
+// 重复的消息直接回复第一次的ack/error，不会再次执行
+func (c *Client) handleIdempotent(msg WSMessage, handle func() (*Task, error)) {
+	key := msg.IdempotencyKey
+	if key == "" {
+		key = msg.RequestID
+	}
+	if key == "" {
+		result, err := handle()
+		c.reply(msg, result, err)
+		return
+	}
+
+	deviceID := c.deviceID
+	if deviceID == "" {
+		if data, ok := msg.Data.(map[string]interface{}); ok {
+			deviceID = getString(data, "device_id")
+		}
+	}
+	data, _ := json.Marshal(msg.Data)
+	scope := idempotencyScope{UserID: c.userID, DeviceID: deviceID, Key: key}
+
+	stored, err := reserveIdempotencyKey(scope, requestFingerprint([]byte(msg.Type), data))
+	if err != nil {
+		c.reply(msg, nil, err)
+		return
+	}
+	if stored != nil {
+		log.Printf("♻️ 重放幂等消息: %s key=%s", msg.Type, key)
+		reply := WSMessage{RequestID: msg.RequestID, Type: "ack"}
+		switch stored.StatusCode {
+		case http.StatusOK:
+		case http.StatusConflict:
+			reply.Type = "conflict"
+		default:
+			reply.Type = "error"
+		}
+		reply.Data = json.RawMessage(stored.Response)
+		if msg.RequestID != "" {
+			c.conn.Send(reply)
+		}
+		return
+	}
+
+	result, err := handle()
+	reply := replyMessage(msg, result, err)
+	if err != nil && errorCode(err) == CodeInternal {
+		releaseIdempotencyKey(scope)
+	} else {
+		status := http.StatusOK
+		if errors.Is(err, ErrConflict) {
+			status = http.StatusConflict
+		} else if err != nil {
+			status = http.StatusBadRequest
+		}
+		response, _ := json.Marshal(reply.Data)
+		completeIdempotencyKey(scope, &idempotentResult{StatusCode: status, ContentType: "application/json", Response: string(response)})
+	}
+	if err != nil {
+		log.Printf("❌ 处理%s消息失败: %v", msg.Type, err)
+	}
+	if msg.RequestID != "" {
+		c.conn.Send(reply)
+	}
+}
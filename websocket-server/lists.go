@@ -0,0 +1,438 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ErrListNotFound 智能列表不存在或不属于当前用户
+var ErrListNotFound = errors.New("未找到列表")
+
+// SavedFilter 智能列表: 保存的筛选表达式和排序，查看时在用户能看到的所有任务上求值
+type SavedFilter struct {
+	ID        string `json:"id"`
+	UserID    string `json:"user_id"`
+	Name      string `json:"name"`
+	Query     string `json:"query"`
+	Sort      string `json:"sort"`
+	TimeZone  string `json:"time_zone"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+const savedFilterColumns = `id, user_id, name, query, COALESCE(sort, ''), COALESCE(time_zone, ''),
+	COALESCE(created_at, ''), COALESCE(updated_at, '')`
+
+func scanSavedFilter(row rowScanner) (*SavedFilter, error) {
+	var f SavedFilter
+	err := row.Scan(&f.ID, &f.UserID, &f.Name, &f.Query, &f.Sort, &f.TimeZone, &f.CreatedAt, &f.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func listSavedFilters(userID string) ([]SavedFilter, error) {
+	rows, err := db.Query("SELECT "+savedFilterColumns+" FROM saved_filters WHERE user_id = ? ORDER BY created_at, id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	filters := []SavedFilter{}
+	for rows.Next() {
+		f, err := scanSavedFilter(rows)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, *f)
+	}
+	return filters, rows.Err()
+}
+
+func getSavedFilter(userID, id string) (*SavedFilter, error) {
+	f, err := scanSavedFilter(db.QueryRow("SELECT "+savedFilterColumns+" FROM saved_filters WHERE id = ? AND user_id = ?", id, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrListNotFound
+	}
+	return f, err
+}
+
+// 保存前检查表达式、排序和时区都能被解析
+func validateSavedFilter(f *SavedFilter) error {
+	if f.Name == "" {
+		return invalidQuery("列表名称不能为空")
+	}
+	if _, err := parseTaskSort(f.Sort); err != nil {
+		return err
+	}
+	loc, err := parseTimeZone(f.TimeZone)
+	if err != nil {
+		return err
+	}
+	_, _, err = compileFilter(f.Query, f.UserID, time.Now(), loc)
+	return err
+}
+
+// 列表对应的任务查询，在用户能看到的所有任务上求值
+func (f *SavedFilter) taskQuery(values url.Values) (TaskQuery, error) {
+	params := url.Values{}
+	for key, value := range values {
+		params[key] = value
+	}
+	if params.Get("sort") == "" && f.Sort != "" {
+		params.Set("sort", f.Sort)
+	}
+	if params.Get("tz") == "" && f.TimeZone != "" {
+		params.Set("tz", f.TimeZone)
+	}
+
+	q, err := parseTaskQuery(params)
+	if err != nil {
+		return q, err
+	}
+	// 额外的filter参数在列表的基础上进一步筛选
+	if extra := params.Get("filter"); extra != "" {
+		q.Filter = "(" + f.Query + ") (" + extra + ")"
+	} else {
+		q.Filter = f.Query
+	}
+
+	membership, err := membershipOf(f.UserID)
+	if err != nil {
+		return q, err
+	}
+	if membership == nil {
+		membership = &Membership{}
+	}
+	q.OwnerIDs = visibleUserIDs(f.UserID, membership.FamilyID, membership.Role)
+	return q, nil
+}
+
+// 发送给userID本人的所有连接
+func sendToUser(userID string, message WSMessage) {
+	hub.broadcast <- Broadcast{Message: message, UserID: userID}
+}
+
+// 智能列表成员的计算在单独的goroutine中按顺序执行，不占用写任务的请求
+type listJob struct {
+	changeType string
+	task       *Task
+	// 列表新建、修改或删除后重新计算（或清除）该列表的成员
+	list    *SavedFilter
+	deleted bool
+}
+
+var listJobs = make(chan listJob, 1024)
+
+// 任务变化后排队计算它在各个智能列表中的成员变化
+func notifySmartLists(changeType string, task *Task) {
+	if changeTypeOf(changeType) == "" {
+		return
+	}
+	copied := *task
+	listJobs <- listJob{changeType: changeType, task: &copied}
+}
+
+func runSmartListWorker() {
+	// 迁移前就存在的列表还没有成员记录，先补算
+	rows, err := db.Query("SELECT " + savedFilterColumns + " FROM saved_filters WHERE COALESCE(members_synced_at, '') = ''")
+	if err != nil {
+		log.Printf("❌ 查询智能列表失败: %v", err)
+	} else {
+		var stale []SavedFilter
+		for rows.Next() {
+			if f, err := scanSavedFilter(rows); err == nil {
+				stale = append(stale, *f)
+			}
+		}
+		rows.Close()
+		for i := range stale {
+			syncListMembers(&stale[i])
+		}
+	}
+
+	for job := range listJobs {
+		job.run()
+	}
+}
+
+func (job listJob) run() {
+	switch {
+	case job.list != nil && job.deleted:
+		if _, err := db.Exec("DELETE FROM smart_list_members WHERE list_id = ?", job.list.ID); err != nil {
+			log.Printf("❌ 清除智能列表成员失败: list=%s, %v", job.list.ID, err)
+		}
+	case job.list != nil:
+		syncListMembers(job.list)
+	default:
+		updateListMembership(job.changeType, job.task)
+	}
+}
+
+// 重新计算列表的全部成员，作为之后比较的基准，不发送通知（客户端会重新拉取列表）
+func syncListMembers(f *SavedFilter) {
+	err := func() error {
+		q, err := f.taskQuery(url.Values{})
+		if err != nil {
+			return err
+		}
+		q.Limit, q.Offset = 0, 0
+		tasks, _, err := taskService.QueryTasks(f.UserID, q)
+		if err != nil {
+			return err
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec("DELETE FROM smart_list_members WHERE list_id = ?", f.ID); err != nil {
+			return err
+		}
+		for i := range tasks {
+			if _, err := tx.Exec("INSERT INTO smart_list_members (list_id, task_id) VALUES (?, ?)", f.ID, getTaskIDString(&tasks[i])); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec("UPDATE saved_filters SET members_synced_at = ? WHERE id = ?",
+			time.Now().UTC().Format(timeLayout), f.ID); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}()
+	if err != nil {
+		log.Printf("❌ 计算智能列表成员失败: list=%s, %v", f.ID, err)
+	}
+}
+
+// 任务变化后与上次的成员记录比较，只有任务进入或离开某个列表时才通知列表的主人。
+// 客户端按matches把任务加入或移出本地的列表；与时间相关的条件（如due<today）随时间变化不会推送
+func updateListMembership(changeType string, task *Task) {
+	recipients, err := changeRecipients(task.UserID)
+	if err != nil {
+		log.Printf("❌ 查询列表通知对象失败: %v", err)
+		return
+	}
+
+	taskID := getTaskIDString(task)
+	for _, userID := range recipients {
+		filters, err := listSavedFilters(userID)
+		if err != nil {
+			log.Printf("❌ 查询智能列表失败: %v", err)
+			continue
+		}
+		for i := range filters {
+			f := &filters[i]
+			matches := false
+			if changeTypeOf(changeType) == ChangeUpsert {
+				if matches, err = savedFilterMatches(f, task); err != nil {
+					log.Printf("❌ 计算智能列表失败: list=%s, %v", f.ID, err)
+					continue
+				}
+			}
+
+			var result sql.Result
+			if matches {
+				result, err = db.Exec("INSERT OR IGNORE INTO smart_list_members (list_id, task_id) VALUES (?, ?)", f.ID, taskID)
+			} else {
+				result, err = db.Exec("DELETE FROM smart_list_members WHERE list_id = ? AND task_id = ?", f.ID, taskID)
+			}
+			if err != nil {
+				log.Printf("❌ 保存智能列表成员失败: list=%s, %v", f.ID, err)
+				continue
+			}
+			if n, _ := result.RowsAffected(); n == 0 {
+				continue
+			}
+
+			data := map[string]interface{}{
+				"list_id": f.ID,
+				"task_id": taskID,
+				"matches": matches,
+			}
+			if matches {
+				data["task"] = task
+			}
+			sendToUser(userID, WSMessage{Type: "list_updated", Data: data})
+		}
+	}
+}
+
+func savedFilterMatches(f *SavedFilter, task *Task) (bool, error) {
+	loc, err := parseTimeZone(f.TimeZone)
+	if err != nil {
+		return false, err
+	}
+	q := TaskQuery{OwnerIDs: []string{task.UserID}, TaskID: getTaskIDString(task), Filter: f.Query, TimeZone: loc, Limit: 1}
+	if err := q.compileFilter(f.UserID, time.Now()); err != nil {
+		return false, err
+	}
+	tasks, _, err := taskService.store.Query(q)
+	return len(tasks) > 0, err
+}
+
+func writeListError(w http.ResponseWriter, err error) {
+	if err == ErrListNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeTaskError(w, err)
+}
+
+// 智能列表REST处理器: GET /api/lists
+func getListsHandler(w http.ResponseWriter, r *http.Request) {
+	filters, err := listSavedFilters(userFromContext(r.Context()).ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(filters)
+}
+
+// POST /api/lists，body为 {name, query, sort, time_zone}
+func createListHandler(w http.ResponseWriter, r *http.Request) {
+	var f SavedFilter
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	suffix, err := randomHex(8)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f.ID = "list_" + suffix
+	f.UserID = userFromContext(r.Context()).ID
+	f.CreatedAt = time.Now().UTC().Format(timeLayout)
+	f.UpdatedAt = f.CreatedAt
+	if err := validateSavedFilter(&f); err != nil {
+		writeListError(w, err)
+		return
+	}
+
+	_, err = db.Exec(`INSERT INTO saved_filters (id, user_id, name, query, sort, time_zone, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, f.ID, f.UserID, f.Name, f.Query, f.Sort, f.TimeZone, f.CreatedAt, f.UpdatedAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("📋 创建智能列表: %s (%s)", f.Name, f.Query)
+	listJobs <- listJob{list: &f}
+	sendToUser(f.UserID, WSMessage{Type: "list_saved", Data: f})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(f)
+}
+
+// GET /api/lists/{id}
+func getListHandler(w http.ResponseWriter, r *http.Request) {
+	f, err := getSavedFilter(userFromContext(r.Context()).ID, mux.Vars(r)["id"])
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f)
+}
+
+// PUT /api/lists/{id}，未提交的字段保持不变
+func updateListHandler(w http.ResponseWriter, r *http.Request) {
+	f, err := getSavedFilter(userFromContext(r.Context()).ID, mux.Vars(r)["id"])
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+	var req struct {
+		Name     *string `json:"name"`
+		Query    *string `json:"query"`
+		Sort     *string `json:"sort"`
+		TimeZone *string `json:"time_zone"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, field := range []struct {
+		value *string
+		dst   *string
+	}{{req.Name, &f.Name}, {req.Query, &f.Query}, {req.Sort, &f.Sort}, {req.TimeZone, &f.TimeZone}} {
+		if field.value != nil {
+			*field.dst = *field.value
+		}
+	}
+	if err := validateSavedFilter(f); err != nil {
+		writeListError(w, err)
+		return
+	}
+
+	f.UpdatedAt = time.Now().UTC().Format(timeLayout)
+	_, err = db.Exec("UPDATE saved_filters SET name = ?, query = ?, sort = ?, time_zone = ?, updated_at = ? WHERE id = ?",
+		f.Name, f.Query, f.Sort, f.TimeZone, f.UpdatedAt, f.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	listJobs <- listJob{list: f}
+	sendToUser(f.UserID, WSMessage{Type: "list_saved", Data: f})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f)
+}
+
+// DELETE /api/lists/{id}
+func deleteListHandler(w http.ResponseWriter, r *http.Request) {
+	userID := userFromContext(r.Context()).ID
+	id := mux.Vars(r)["id"]
+	result, err := db.Exec("DELETE FROM saved_filters WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		writeListError(w, ErrListNotFound)
+		return
+	}
+	listJobs <- listJob{list: &SavedFilter{ID: id}, deleted: true}
+	sendToUser(userID, WSMessage{Type: "list_deleted", Data: map[string]string{"id": id}})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "列表已删除"})
+}
+
+// GET /api/lists/{id}/tasks，支持与GET /api/tasks相同的分页、排序和筛选参数
+func getListTasksHandler(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	f, err := getSavedFilter(user.ID, mux.Vars(r)["id"])
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+	q, err := f.taskQuery(r.URL.Query())
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+
+	response := TasksResponse{Limit: q.Limit, Offset: q.Offset}
+	if response.Tasks, response.Total, err = taskService.QueryTasks(user.ID, q); err != nil {
+		writeListError(w, err)
+		return
+	}
+	if len(response.Tasks) == q.Limit {
+		response.NextCursor = encodeTaskCursor(q.Sort, &response.Tasks[len(response.Tasks)-1])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
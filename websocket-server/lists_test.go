@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// 执行队列中的智能列表任务，返回期间发出的list_updated（列表:任务标题:是否匹配）
+func runListJobs(t *testing.T) string {
+	t.Helper()
+	for {
+		select {
+		case job := <-listJobs:
+			job.run()
+			continue
+		default:
+		}
+		break
+	}
+	var updates []string
+	for {
+		select {
+		case b := <-hub.broadcast:
+			if b.Message.Type != "list_updated" {
+				continue
+			}
+			data := b.Message.Data.(map[string]interface{})
+			title := data["task_id"]
+			if task, ok := data["task"].(*Task); ok {
+				title = task.Title
+			}
+			updates = append(updates, fmt.Sprintf("%s>%s:%v:%v", b.UserID, data["list_id"], title, data["matches"]))
+		default:
+			return strings.Join(updates, ",")
+		}
+	}
+}
+
+func TestSmartListNotifiesOnlyOnMembershipChange(t *testing.T) {
+	openTestDB(t)
+	addTestMember(t, "f1", "parent", RoleParent)
+	addTestMember(t, "f1", "child", RoleChild)
+
+	math := createTestTask(t, "parent", Task{UserID: "child", Title: "数学", Category: "学习"})
+	runListJobs(t)
+	study := &SavedFilter{ID: "study", UserID: "parent", Name: "学习", Query: "category:学习"}
+	if _, err := db.Exec("INSERT INTO saved_filters (id, user_id, name, query) VALUES (?, ?, ?, ?)",
+		study.ID, study.UserID, study.Name, study.Query); err != nil {
+		t.Fatal(err)
+	}
+	listJobs <- listJob{list: study}
+	if got := runListJobs(t); got != "" {
+		t.Errorf("计算列表成员时不应通知: %s", got)
+	}
+
+	steps := []struct {
+		name   string
+		action func() error
+		want   string
+	}{
+		{"仍在列表中的任务被修改", func() error {
+			math.Title = "数学作业"
+			return taskService.UpdateTask("parent", math)
+		}, ""},
+		{"任务离开列表", func() error {
+			math.Category = "运动"
+			return taskService.UpdateTask("parent", math)
+		}, "parent>study:" + getTaskIDString(math) + ":false"},
+		{"不在列表中的任务被修改", func() error {
+			math.Title = "数学"
+			return taskService.UpdateTask("parent", math)
+		}, ""},
+		{"任务回到列表", func() error {
+			math.Category = "学习"
+			return taskService.UpdateTask("parent", math)
+		}, "parent>study:数学:true"},
+		{"孩子更新进度，任务仍在列表中", func() error {
+			math.DailyProgress = `{"2026-10-17":{"progress":50}}`
+			return taskService.UpdateTask("child", math)
+		}, ""},
+		{"删除任务离开列表", func() error {
+			return taskService.DeleteTask("parent", math)
+		}, "parent>study:" + getTaskIDString(math) + ":false"},
+		{"新建符合条件的任务进入列表", func() error {
+			createTestTask(t, "parent", Task{UserID: "child", Title: "语文", Category: "学习"})
+			return nil
+		}, "parent>study:语文:true"},
+	}
+	for _, step := range steps {
+		if err := step.action(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got := runListJobs(t); got != step.want {
+			t.Errorf("%s: list_updated = %q, want %q", step.name, got, step.want)
+		}
+	}
+
+	listJobs <- listJob{list: study, deleted: true}
+	runListJobs(t)
+	var members int
+	db.QueryRow("SELECT COUNT(*) FROM smart_list_members WHERE list_id = ?", study.ID).Scan(&members)
+	if members != 0 {
+		t.Errorf("删除列表后还有%d条成员记录", members)
+	}
+}
@@ -1,15 +1,16 @@
 package main
 
 import (
-	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
 	"strconv"
-	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
@@ -33,6 +34,38 @@ type Task struct {
 	CreatedAt     string      `json:"created_at" db:"created_at"`
 	UpdatedAt     string      `json:"updated_at" db:"updated_at"`
 	DailyProgress string      `json:"daily_progress" db:"daily_progress"` // JSON格式存储每日进度
+	CreatedBy     string      `json:"created_by" db:"created_by"`         // 创建者，家长分配任务时与UserID不同
+
+	// 完成审批: 孩子提交完成后为pending，家长审批后为approved/rejected
+	ApprovalStatus        string `json:"approval_status" db:"approval_status"`
+	ApprovalComment       string `json:"approval_comment" db:"approval_comment"`
+	CompletionRequestedAt string `json:"completion_requested_at" db:"completion_requested_at"`
+	ReviewedBy            string `json:"reviewed_by" db:"reviewed_by"`
+	ReviewedAt            string `json:"reviewed_at" db:"reviewed_at"`
+
+	Points int `json:"points" db:"points"` // 完成（审批通过）后奖励的积分
+
+	// 工作进度: 完成百分比(0-100)、累计用时（小时）和进度备注，由iOS的WorkManager维护
+	WorkProgress  float64 `json:"work_progress" db:"work_progress"`
+	TimeSpent     float64 `json:"time_spent" db:"time_spent"`
+	ProgressNotes string  `json:"progress_notes" db:"progress_notes"`
+
+	// 重复任务: RFC 5545 RRULE（如 FREQ=WEEKLY;BYDAY=MO,WE）和逗号分隔的排除日期
+	RRule   string `json:"rrule" db:"rrule"`
+	ExDates string `json:"exdates" db:"exdates"`
+	// 展开重复任务的时区: IANA名称或+08:00形式的偏移，为空时按开始时间自带的时区
+	TimeZone string `json:"time_zone" db:"time_zone"`
+	// 提醒偏移: 逗号分隔的提前分钟数，如 "15,1440" 或 "start:0,due:30"
+	ReminderOffsets string `json:"reminder_offsets" db:"reminder_offsets"`
+	// 软删除: 删除时间和删除者，未删除时为空
+	DeletedAt string `json:"deleted_at,omitempty" db:"deleted_at"`
+	DeletedBy string `json:"deleted_by,omitempty" db:"deleted_by"`
+	// 版本号，每次修改加1，客户端修改时带上基础版本用于发现冲突
+	Revision int64 `json:"revision" db:"revision"`
+	// 每个字段最后一次修改时的版本号，由服务器维护，用于合并并发修改
+	FieldRevisions map[string]int64 `json:"field_revisions,omitempty" db:"field_revisions"`
+	// 展开重复任务时该实例的日期(YYYY-MM-DD)，不存储
+	OccurrenceDate string `json:"occurrence_date,omitempty" db:"-"`
 }
 
 // API响应结构体
@@ -41,116 +74,230 @@ type TasksResponse struct {
 	Total  int    `json:"total"`
 	Limit  int    `json:"limit"`
 	Offset int    `json:"offset"`
+	// 下一页的keyset游标，没有更多时为空
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 // WebSocket消息类型
 type WSMessage struct {
 	Type string      `json:"type"`
 	Data interface{} `json:"data"`
+	// 客户端生成的请求ID，服务器在ack/error回复中原样带回
+	RequestID string `json:"request_id,omitempty"`
+	// 幂等键，重试时保持不变；没有时使用request_id
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// 接收者的变更游标，断线重连后用sync_since从这里继续
+	Cursor int64 `json:"cursor,omitempty"`
+}
+
+// WebSocket客户端连接，连接时绑定用户及其家庭身份
+type Client struct {
+	conn     *wsConn
+	userID   string
+	deviceID string // 握手时的device_id参数或X-Device-ID头，用于幂等键
+	familyID string
+	role     string
+}
+
+// 带接收范围的广播消息
+type Broadcast struct {
+	Message     WSMessage
+	UserID      string           // 任务所属用户
+	FamilyIDs   []string         // 任务所属用户加入的家庭
+	ParentsOnly bool             // 只发送给家庭中的家长（例如完成审批申请）
+	Cursors     map[string]int64 // 每个接收用户的变更游标
+}
+
+// 客户端是否有权收到该广播：同一用户，或同一家庭的家长
+func (c *Client) entitled(b Broadcast) bool {
+	if c.userID == b.UserID {
+		return !b.ParentsOnly
+	}
+	if c.familyID == "" || c.role != RoleParent {
+		return false
+	}
+	for _, familyID := range b.FamilyIDs {
+		if familyID == c.familyID {
+			return true
+		}
+	}
+	return false
 }
 
 // WebSocket连接管理
 type Hub struct {
-	clients    map[*websocket.Conn]bool
-	broadcast  chan WSMessage
-	register   chan *websocket.Conn
-	unregister chan *websocket.Conn
+	clients    map[*Client]bool
+	broadcast  chan Broadcast
+	register   chan *Client
+	unregister chan *Client
 }
 
 var (
-	hub          *Hub
-	sqliteAPIURL = "http://localhost:8080"
-	db           *sql.DB
+	hub         *Hub
+	db          *sql.DB
+	taskService *TaskService
 )
 
 // WebSocket升级器
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // 允许所有来源
-	},
+	CheckOrigin: checkOrigin,
 }
 
-// 初始化数据库
-func initDB() {
+// 打开数据库连接
+func openDB(path string) {
 	var err error
-	db, err = sql.Open("sqlite3", "./tasks.db")
+	db, err = sql.Open("sqlite3", path)
 	if err != nil {
 		log.Fatal("❌ 无法打开数据库:", err)
 	}
+}
+
+// 初始化数据库：执行未完成的迁移，数据库版本高于程序时拒绝启动
+func initDB(path string) {
+	openDB(path)
+
+	if err := migrateOnStartup(db); err != nil {
+		log.Fatal("❌ 数据库迁移失败: ", err)
+	}
+	if err := ensureSearchIndex(db); err != nil {
+		log.Fatal("❌ 全文索引初始化失败: ", err)
+	}
+
+	log.Println("✅ 数据库初始化完成")
+}
 
-	// 创建表
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS tasks (
-		id TEXT PRIMARY KEY,
-		user_id TEXT DEFAULT 'default_user',
-		title TEXT NOT NULL,
-		description TEXT,
-		start_date TEXT,
-		due_date TEXT,
-		is_completed INTEGER DEFAULT 0,
-		category TEXT DEFAULT '学习',
-		priority INTEGER DEFAULT 1,
-		device_id TEXT,
-		record_id TEXT,
-		created_at TEXT DEFAULT CURRENT_TIMESTAMP,
-		updated_at TEXT DEFAULT CURRENT_TIMESTAMP,
-		daily_progress TEXT DEFAULT '{}'
-	);`
-
-	_, err = db.Exec(createTableSQL)
+func main() {
+	args, err := loadConfig(os.Args[1:])
 	if err != nil {
-		log.Fatal("❌ 无法创建表:", err)
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(2)
 	}
 
-	// 为现有表添加新字段（如果不存在）
-	alterTableSQL := []string{
-		"ALTER TABLE tasks ADD COLUMN category TEXT DEFAULT '学习'",
-		"ALTER TABLE tasks ADD COLUMN priority INTEGER DEFAULT 1",
-		"ALTER TABLE tasks ADD COLUMN record_id TEXT",
-		"ALTER TABLE tasks ADD COLUMN start_date TEXT",
-		"ALTER TABLE tasks ADD COLUMN daily_progress TEXT DEFAULT '{}'",
+	// 数据库迁移子命令: websocket-server [-db path] migrate status|up|down|to N
+	if len(args) > 0 && args[0] == "migrate" {
+		openDB(config.DBPath)
+		defer db.Close()
+		if err := runMigrateCommand(db, args[1:]); err != nil {
+			log.Fatal("❌ ", err)
+		}
+		return
 	}
 
-	for _, sql := range alterTableSQL {
-		_, err = db.Exec(sql)
+	// 认领旧任务子命令: websocket-server [-db path] claim-legacy <用户名>
+	if len(args) > 0 && args[0] == "claim-legacy" {
+		if len(args) < 2 {
+			log.Fatal("❌ 用法: claim-legacy <用户名>")
+		}
+		initDB(config.DBPath)
+		defer db.Close()
+		count, err := claimLegacyTasks(args[1])
 		if err != nil {
-			// 字段可能已存在，忽略错误
-			log.Printf("⚠️ ALTER TABLE警告 (可能字段已存在): %v", err)
+			log.Fatal("❌ ", err)
 		}
+		log.Printf("✅ %d个旧任务已转给用户 %s", count, args[1])
+		return
 	}
 
-	log.Println("✅ 数据库初始化完成")
-}
-
-func main() {
-	// 初始化数据库
-	initDB()
+	// 初始化数据库，服务器自己负责全部持久化
+	initDB(config.DBPath)
 	defer db.Close()
+	if count, err := countLegacyTasks(); err != nil {
+		log.Printf("❌ 查询旧任务失败: %v", err)
+	} else if count > 0 {
+		log.Printf("⚠️ 有%d个启用登录之前创建的任务不属于任何账号，运行 websocket-server claim-legacy <用户名> 把它们转给该用户", count)
+	}
 
-	// 测试API连接
-	testAPIConnection()
+	taskService = NewTaskService(NewSQLiteTaskStore(db))
 
 	// 初始化WebSocket Hub
 	hub = &Hub{
-		clients:    make(map[*websocket.Conn]bool),
-		broadcast:  make(chan WSMessage),
-		register:   make(chan *websocket.Conn),
-		unregister: make(chan *websocket.Conn),
+		clients:    make(map[*Client]bool),
+		broadcast:  make(chan Broadcast, 1024),
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
 	}
 
 	// 启动WebSocket Hub
 	go hub.run()
 
+	go purgeIdempotencyKeys()
+	go runSmartListWorker()
+	if config.TrashRetention > 0 {
+		go purgeTrash(taskService.store, config.TrashRetention)
+	}
+
+	// 启动提醒调度器
+	if config.ReminderInterval > 0 {
+		scheduler := NewReminderScheduler(taskService.store, config.ReminderInterval, config.ReminderLookback, config.ReminderWebhookURL)
+		go scheduler.Run()
+	}
+
 	// 设置路由
 	router := mux.NewRouter()
 
 	// REST API路由
 	router.HandleFunc("/health", healthHandler).Methods("GET")
-	router.HandleFunc("/api/tasks", getTasksHandler).Methods("GET")
-	router.HandleFunc("/api/tasks", createTaskHandler).Methods("POST")
-	router.HandleFunc("/api/tasks/{id}", getTaskHandler).Methods("GET")
-	router.HandleFunc("/api/tasks/{id}", updateTaskHandler).Methods("PUT")
-	router.HandleFunc("/api/tasks/{id}", deleteTaskHandler).Methods("DELETE")
+
+	// 认证路由
+	router.HandleFunc("/api/auth/register", registerHandler).Methods("POST")
+	router.HandleFunc("/api/auth/login", loginHandler).Methods("POST")
+	router.Handle("/api/auth/logout", requireAuth(http.HandlerFunc(logoutHandler))).Methods("POST")
+	router.Handle("/api/auth/me", requireAuth(http.HandlerFunc(meHandler))).Methods("GET")
+
+	// 任务路由，需要Bearer token
+	tasksRouter := router.PathPrefix("/api/tasks").Subrouter()
+	tasksRouter.Use(requireAuth, idempotent)
+	tasksRouter.HandleFunc("", getTasksHandler).Methods("GET")
+	tasksRouter.HandleFunc("", createTaskHandler).Methods("POST")
+	tasksRouter.HandleFunc("/search", searchTasksHandler).Methods("GET")
+	tasksRouter.HandleFunc("/{id}", getTaskHandler).Methods("GET")
+	tasksRouter.HandleFunc("/{id}", updateTaskHandler).Methods("PUT")
+	tasksRouter.HandleFunc("/{id}", patchTaskHandler).Methods("PATCH")
+	tasksRouter.HandleFunc("/{id}", deleteTaskHandler).Methods("DELETE")
+	tasksRouter.HandleFunc("/{id}/approve", reviewCompletionHandler(true)).Methods("POST")
+	tasksRouter.HandleFunc("/{id}/reject", reviewCompletionHandler(false)).Methods("POST")
+	tasksRouter.HandleFunc("/{id}/occurrences/{date}", updateOccurrenceHandler).Methods("PUT")
+	tasksRouter.HandleFunc("/{id}/restore", restoreTaskHandler).Methods("POST")
+	tasksRouter.HandleFunc("/{id}/progress", listProgressHandler).Methods("GET")
+	tasksRouter.HandleFunc("/{id}/progress/{date}", getProgressHandler).Methods("GET")
+	tasksRouter.HandleFunc("/{id}/progress/{date}", updateProgressHandler).Methods("PUT")
+
+	// 回收站
+	router.Handle("/api/trash", requireAuth(http.HandlerFunc(getTrashHandler))).Methods("GET")
+
+	// 家庭路由
+	familyRouter := router.PathPrefix("/api/family").Subrouter()
+	familyRouter.Use(requireAuth, idempotent)
+	familyRouter.HandleFunc("", getFamilyHandler).Methods("GET")
+	familyRouter.HandleFunc("", createFamilyHandler).Methods("POST")
+	familyRouter.HandleFunc("/children", createChildHandler).Methods("POST")
+	familyRouter.HandleFunc("/members", addFamilyMemberHandler).Methods("POST")
+	familyRouter.HandleFunc("/members/{user_id}", removeFamilyMemberHandler).Methods("DELETE")
+	familyRouter.HandleFunc("/invitations", getInvitationsHandler).Methods("GET")
+	familyRouter.HandleFunc("/invitations/{id}/accept", acceptInvitationHandler).Methods("POST")
+	familyRouter.HandleFunc("/invitations/{id}", deleteInvitationHandler).Methods("DELETE")
+
+	// 增量同步
+	router.Handle("/api/changes", requireAuth(http.HandlerFunc(getChangesHandler))).Methods("GET")
+
+	// 智能列表
+	listsRouter := router.PathPrefix("/api/lists").Subrouter()
+	listsRouter.Use(requireAuth, idempotent)
+	listsRouter.HandleFunc("", getListsHandler).Methods("GET")
+	listsRouter.HandleFunc("", createListHandler).Methods("POST")
+	listsRouter.HandleFunc("/{id}", getListHandler).Methods("GET")
+	listsRouter.HandleFunc("/{id}", updateListHandler).Methods("PUT")
+	listsRouter.HandleFunc("/{id}", deleteListHandler).Methods("DELETE")
+	listsRouter.HandleFunc("/{id}/tasks", getListTasksHandler).Methods("GET")
+
+	// 积分路由
+	pointsRouter := router.PathPrefix("/api/points").Subrouter()
+	pointsRouter.Use(requireAuth, idempotent)
+	pointsRouter.HandleFunc("/balance", pointsBalanceHandler).Methods("GET")
+	pointsRouter.HandleFunc("/history", pointsHistoryHandler).Methods("GET")
+	pointsRouter.HandleFunc("/redeem", redeemPointsHandler).Methods("POST")
 
 	// WebSocket路由
 	router.HandleFunc("/ws", wsHandler)
@@ -158,32 +305,18 @@ func main() {
 	// 设置CORS
 	c := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders: []string{"*"},
 	})
 
 	handler := c.Handler(router)
 
-	fmt.Println("🚀 WebSocket服务器启动在端口 8082")
-	fmt.Println("📡 WebSocket端点: ws://localhost:8082/ws")
-	fmt.Println("🔗 REST API端点: http://localhost:8082/api/tasks")
-	fmt.Println("🔖 版本: v1.0.4 - 改进的自动部署系统")
-	log.Fatal(http.ListenAndServe(":8082", handler))
-}
-
-// 测试SQLite API连接
-func testAPIConnection() {
-	resp, err := http.Get("http://localhost:8080/health")
-	if err != nil {
-		log.Fatal("SQLite API连接失败:", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 200 {
-		fmt.Println("✅ SQLite API连接成功")
-	} else {
-		log.Fatal("SQLite API健康检查失败:", resp.StatusCode)
-	}
+	fmt.Printf("🚀 WebSocket服务器启动在 %s\n", config.Addr)
+	fmt.Printf("📡 WebSocket端点: ws://localhost%s/ws\n", config.Addr)
+	fmt.Printf("🔗 REST API端点: http://localhost%s/api/tasks\n", config.Addr)
+	fmt.Printf("💾 数据库: %s\n", config.DBPath)
+	fmt.Println("🔖 版本: v1.1.0 - 独立运行，不再依赖8080端口的SQLite API")
+	log.Fatal(http.ListenAndServe(config.Addr, handler))
 }
 
 // WebSocket Hub运行
@@ -192,59 +325,82 @@ func (h *Hub) run() {
 		select {
 		case client := <-h.register:
 			h.clients[client] = true
-			log.Printf("客户端连接，当前连接数: %d", len(h.clients))
+			log.Printf("客户端连接: user=%s, family=%s, 当前连接数: %d", client.userID, client.familyID, len(h.clients))
 
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
-				client.Close()
-				log.Printf("客户端断开，当前连接数: %d", len(h.clients))
+				client.conn.Close()
+				log.Printf("客户端断开: user=%s, 当前连接数: %d", client.userID, len(h.clients))
 			}
 
-		case message := <-h.broadcast:
-			log.Printf("📡 收到广播消息: type=%s, 目标客户端数=%d", message.Type, len(h.clients))
+		case b := <-h.broadcast:
+			log.Printf("📡 收到广播消息: type=%s, user=%s, 总连接数=%d", b.Message.Type, b.UserID, len(h.clients))
+			targetCount := 0
 			successCount := 0
 			for client := range h.clients {
-				err := client.WriteJSON(message)
-				if err != nil {
-					log.Printf("❌ 发送消息失败: %v", err)
-					delete(h.clients, client)
-					client.Close()
-				} else {
+				if !client.entitled(b) {
+					continue
+				}
+				targetCount++
+				message := b.Message
+				message.Cursor = b.Cursors[client.userID]
+				if client.conn.Send(message) {
 					successCount++
+				} else {
+					// 发送队列已满的客户端已被断开，重新连接后全量同步
+					delete(h.clients, client)
 				}
 			}
-			log.Printf("✅ 广播完成: 成功发送给 %d/%d 个客户端", successCount, len(h.clients))
+			log.Printf("✅ 广播完成: 成功发送给 %d/%d 个客户端", successCount, targetCount)
 		}
 	}
 }
 
 // WebSocket处理器
 func wsHandler(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	// 握手阶段校验token，连接绑定到认证用户
+	user, err := authenticateRequest(r, true)
+	if err != nil {
+		http.Error(w, ErrUnauthorized.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// 家庭身份由服务器根据账号确定
+	membership, err := membershipOf(user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if membership == nil {
+		membership = &Membership{}
+	}
+
+	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket升级失败: %v", err)
 		return
 	}
 
-	hub.register <- conn
+	conn := newWSConn(ws, user.ID)
+	deviceID := r.URL.Query().Get("device_id")
+	if deviceID == "" {
+		deviceID = r.Header.Get("X-Device-ID")
+	}
+	client := &Client{conn: conn, userID: user.ID, deviceID: deviceID, familyID: membership.FamilyID, role: membership.Role}
+	hub.register <- client
 
-	// 发送当前所有任务给新连接的客户端
-	go func() {
-		tasks, err := getAllTasks("default_user")
-		if err == nil {
-			message := WSMessage{
-				Type: "tasks_sync",
-				Data: tasks,
-			}
-			conn.WriteJSON(message)
-		}
-	}()
+	// 带since参数重连时只发送游标之后的变更
+	if sinceValue := r.URL.Query().Get("since"); sinceValue != "" {
+		go handleSyncSince(client, WSMessage{Type: "sync_since", Data: map[string]interface{}{"since": sinceValue}})
+	} else {
+		go sendTasksSync(client)
+	}
 
 	// 处理客户端消息
 	go func() {
 		defer func() {
-			hub.unregister <- conn
+			hub.unregister <- client
 		}()
 
 		for {
@@ -257,30 +413,114 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 
 			// 处理不同类型的消息
 			log.Printf("📨 收到WebSocket消息类型: %s", msg.Type)
+			var handle func() (*Task, error)
 			switch msg.Type {
 			case "ping":
-				conn.WriteJSON(WSMessage{Type: "pong", Data: "ok"})
+				conn.Send(WSMessage{Type: "pong", Data: "ok"})
+				continue
+			case "sync_since":
+				handleSyncSince(client, msg)
+				continue
 			case "create_task":
-				handleCreateTask(msg.Data)
+				handle = func() (*Task, error) { return handleCreateTask(client, msg.Data) }
 			case "update_task":
-				handleUpdateTask(msg.Data)
+				handle = func() (*Task, error) { return handleUpdateTask(client, msg.Data) }
+			case "patch_task":
+				handle = func() (*Task, error) { return handlePatchTask(client, msg.Data) }
 			case "delete_task":
-				handleDeleteTask(msg.Data)
+				handle = func() (*Task, error) { return handleDeleteTask(client, msg.Data) }
+			case "approve_completion":
+				handle = func() (*Task, error) { return handleReviewCompletion(client, msg.Data, true) }
+			case "reject_completion":
+				handle = func() (*Task, error) { return handleReviewCompletion(client, msg.Data, false) }
+			case "complete_occurrence":
+				handle = func() (*Task, error) { return handleCompleteOccurrence(client, msg.Data) }
+			case "update_progress":
+				handle = func() (*Task, error) { return handleUpdateProgress(client, msg.Data) }
 			default:
 				log.Printf("❓ 未知消息类型: %s", msg.Type)
+				client.reply(msg, nil, ErrUnknownMessage)
+				continue
 			}
+			client.handleIdempotent(msg, handle)
 		}
 	}()
 }
 
-// 广播任务变更
+// 发送该连接有权看到的所有任务，带上当前游标供之后增量同步
+func sendTasksSync(client *Client) {
+	cursor, err := currentCursor(client.userID)
+	if err != nil {
+		log.Printf("❌ 查询变更游标失败: %v", err)
+	}
+
+	tasks := []Task{}
+	for _, id := range visibleUserIDs(client.userID, client.familyID, client.role) {
+		userTasks, err := taskService.ListTasks(client.userID, id)
+		if err != nil {
+			log.Printf("❌ 获取任务失败: user=%s, %v", id, err)
+			return
+		}
+		tasks = append(tasks, userTasks...)
+	}
+	client.conn.Send(WSMessage{
+		Type:   "tasks_sync",
+		Data:   tasks,
+		Cursor: cursor,
+	})
+}
+
+// 广播任务变更，只发送给任务所属用户及其家庭中家长的连接
 func broadcastTaskChange(changeType string, task *Task) {
+	sendTaskBroadcast(changeType, task, false)
+	notifySmartLists(changeType, task)
+}
+
+// 只通知任务所属用户家庭中的家长
+func broadcastToParents(changeType string, task *Task) {
+	sendTaskBroadcast(changeType, task, true)
+}
+
+func sendTaskBroadcast(changeType string, task *Task, parentsOnly bool) {
 	message := WSMessage{
 		Type: changeType,
 		Data: task,
 	}
-	log.Printf("🔊 准备广播消息: type=%s, task=%s, 连接数=%d", changeType, task.Title, len(hub.clients))
-	hub.broadcast <- message
+	log.Printf("🔊 准备广播消息: type=%s, task=%s, user=%s", changeType, task.Title, task.UserID)
+
+	changeMu.Lock()
+	defer changeMu.Unlock()
+
+	var cursors map[string]int64
+	if change := changeTypeOf(changeType); change != "" {
+		var err error
+		if cursors, err = recordTaskChange(task, change); err != nil {
+			log.Printf("❌ 记录任务变更失败: %v", err)
+		}
+	}
+	pushBroadcast(task.UserID, message, parentsOnly, cursors)
+}
+
+// 发送给userID本人及其家庭中家长的连接
+func sendBroadcast(userID string, message WSMessage, parentsOnly bool) {
+	changeMu.Lock()
+	defer changeMu.Unlock()
+	pushBroadcast(userID, message, parentsOnly, nil)
+}
+
+// 放入Hub的广播队列，cursors为空时带上接收者当前的游标。调用方需持有changeMu
+func pushBroadcast(userID string, message WSMessage, parentsOnly bool, cursors map[string]int64) {
+	families, err := familiesOfUser(userID)
+	if err != nil {
+		log.Printf("❌ 查询用户家庭失败: %v", err)
+	}
+	if cursors == nil {
+		if cursors, err = currentCursors(userID); err != nil {
+			log.Printf("❌ 查询变更游标失败: %v", err)
+		}
+	}
+
+	hub.broadcast <- Broadcast{Message: message, UserID: userID, FamilyIDs: families, ParentsOnly: parentsOnly, Cursors: cursors}
 	log.Printf("✅ 消息已发送到广播通道")
 }
 
@@ -290,22 +530,168 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
+// 将业务层错误映射为HTTP状态码
+func writeTaskError(w http.ResponseWriter, err error) {
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		writeConflict(w, http.StatusConflict, conflict)
+		return
+	}
+
+	switch err {
+	case ErrTaskNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case ErrForbidden:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		if errors.Is(err, ErrInvalidRecurrence) || errors.Is(err, ErrInvalidReminder) || errors.Is(err, ErrInvalidPatch) ||
+			errors.Is(err, ErrInvalidQuery) || errors.Is(err, ErrInvalidFilter) || errors.Is(err, ErrInvalidProgress) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// 创建/修改任务的请求体，assignee_id用于家长把任务分配给家庭成员。
+// points/rrule/exdates/time_zone/reminder_offsets和工作进度未提交时保留原值，旧客户端修改任务不会清掉这些设置
+type taskRequest struct {
+	Task
+	AssigneeID      string   `json:"assignee_id"`
+	Points          *int     `json:"points"`
+	RRule           *string  `json:"rrule"`
+	ExDates         *string  `json:"exdates"`
+	TimeZone        *string  `json:"time_zone"`
+	ReminderOffsets *string  `json:"reminder_offsets"`
+	WorkProgress    *float64 `json:"work_progress"`
+	TimeSpent       *float64 `json:"time_spent"`
+	ProgressNotes   *string  `json:"progress_notes"`
+}
+
+func (req *taskRequest) applyOptionalFields(task *Task) {
+	if req.Points != nil {
+		task.Points = *req.Points
+	}
+	if req.RRule != nil {
+		task.RRule = *req.RRule
+	}
+	if req.ExDates != nil {
+		task.ExDates = *req.ExDates
+	}
+	if req.TimeZone != nil {
+		task.TimeZone = *req.TimeZone
+	}
+	if req.ReminderOffsets != nil {
+		task.ReminderOffsets = *req.ReminderOffsets
+	}
+	req.applyWorkProgress(task)
+}
+
+func (req *taskRequest) applyWorkProgress(task *Task) {
+	if req.WorkProgress != nil {
+		task.WorkProgress = *req.WorkProgress
+	}
+	if req.TimeSpent != nil {
+		task.TimeSpent = *req.TimeSpent
+	}
+	if req.ProgressNotes != nil {
+		task.ProgressNotes = *req.ProgressNotes
+	}
+}
+
+// 任务列表: GET /api/tasks。带category/completed/priority/due_from/due_to/start_from/start_to/
+// device_id/sort/limit/offset/cursor任一参数时返回TasksResponse，否则保持旧客户端使用的任务数组
 func getTasksHandler(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("userId")
-	if userID == "" {
-		userID = "default_user"
+	user := userFromContext(r.Context())
+	values := r.URL.Query()
+
+	// 家长可以通过user_id查看孩子的任务
+	ownerID := values.Get("user_id")
+	if ownerID == "" {
+		ownerID = user.ID
 	}
 
-	tasks, err := getAllTasks(userID)
+	if wantsTasksEnvelope(values) {
+		queryTasksHandler(w, user.ID, ownerID, values)
+		return
+	}
+
+	tasks, err := taskService.ListTasks(user.ID, ownerID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeTaskError(w, err)
 		return
 	}
 
+	// 指定from/to时按日期窗口返回，重复任务展开为每次实例
+	if from := values.Get("from"); from != "" {
+		start, end, err := parseExpandWindow(from, values.Get("to"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if tasks, err = expandTasks(tasks, start, end); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(tasks)
 }
 
+// 带筛选、排序和分页的任务列表。同时指定from/to时先筛选再展开重复任务，按实例分页
+func queryTasksHandler(w http.ResponseWriter, actorID, ownerID string, values url.Values) {
+	q, err := parseTaskQuery(values)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.OwnerIDs = []string{ownerID}
+
+	response := TasksResponse{Limit: q.Limit, Offset: q.Offset}
+	if from := values.Get("from"); from != "" {
+		if q.Cursor != nil {
+			http.Error(w, "展开重复任务时不支持cursor", http.StatusBadRequest)
+			return
+		}
+		start, end, err := parseExpandWindow(from, values.Get("to"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		all := q
+		all.Limit, all.Offset = 0, 0
+		tasks, _, err := taskService.QueryTasks(actorID, all)
+		if err != nil {
+			writeTaskError(w, err)
+			return
+		}
+		if tasks, err = expandTasks(tasks, start, end); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response.Total = len(tasks)
+		if q.Offset > len(tasks) {
+			q.Offset = len(tasks)
+		}
+		if end := q.Offset + q.Limit; end < len(tasks) {
+			tasks = tasks[:end]
+		}
+		response.Tasks = tasks[q.Offset:]
+	} else {
+		if response.Tasks, response.Total, err = taskService.QueryTasks(actorID, q); err != nil {
+			writeTaskError(w, err)
+			return
+		}
+		if len(response.Tasks) == q.Limit {
+			response.NextCursor = encodeTaskCursor(q.Sort, &response.Tasks[len(response.Tasks)-1])
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
 func getTaskHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
@@ -314,25 +700,35 @@ func getTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	task, err := getTaskByID(id)
+	task, err := taskService.GetTaskForUser(userFromContext(r.Context()).ID, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeTaskError(w, err)
 		return
 	}
 
+	setETag(w, task)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(task)
 }
 
 func createTaskHandler(w http.ResponseWriter, r *http.Request) {
-	var task Task
-	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
+	var req taskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	user := userFromContext(r.Context())
+
+	// 任务默认归属于当前登录用户，家长可以指定assignee_id分配给孩子
+	task := req.Task
+	task.UserID = user.ID
+	if req.AssigneeID != "" {
+		task.UserID = req.AssigneeID
+	}
+	req.applyOptionalFields(&task)
 
 	// 检查是否存在重复任务
-	existingTask, err := findDuplicateTask(task.Title, task.DeviceID, task.UserID)
+	existingTask, err := taskService.FindDuplicate(task.Title, task.DeviceID, task.UserID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -344,37 +740,26 @@ func createTaskHandler(w http.ResponseWriter, r *http.Request) {
 		existingTask.DueDate = task.DueDate
 		existingTask.IsCompleted = task.IsCompleted
 		existingTask.RecordID = task.RecordID
-		existingTask.UpdatedAt = time.Now().Format("2006-01-02 15:04:05")
+		req.applyWorkProgress(existingTask)
 
-		if err := updateTask(existingTask); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err := taskService.UpdateTask(user.ID, existingTask); err != nil {
+			writeTaskError(w, err)
 			return
 		}
 
-		// 广播更新
-		broadcastTaskChange("task_updated", existingTask)
-
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(existingTask)
 		return
 	}
 
 	// 创建新任务
-	task.UserID = "default_user"
-	task.CreatedAt = time.Now().Format("2006-01-02 15:04:05")
-	task.UpdatedAt = time.Now().Format("2006-01-02 15:04:05")
-
-	id, err := createTask(&task)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	task.ID = nil
+	if err := taskService.CreateTask(user.ID, &task); err != nil {
+		writeTaskError(w, err)
 		return
 	}
 
-	task.ID = id
-
-	// 广播新任务
-	broadcastTaskChange("task_created", &task)
-
+	setETag(w, &task)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(task)
 }
@@ -387,23 +772,64 @@ func updateTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var task Task
-	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	user := userFromContext(r.Context())
+	existingTask, err := taskService.GetTaskForUser(user.ID, id)
+	if err != nil {
+		writeTaskError(w, err)
 		return
 	}
 
-	task.ID = id
-	task.UpdatedAt = time.Now().Format("2006-01-02 15:04:05")
+	var req taskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	if err := updateTask(&task); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// ID、所属用户和创建时间以服务器为准，重新分配需要通过assignee_id
+	task := req.Task
+	task.ID = existingTask.ID
+	task.UserID = existingTask.UserID
+	if req.AssigneeID != "" {
+		task.UserID = req.AssigneeID
+	}
+	task.CreatedAt = existingTask.CreatedAt
+	task.Points = existingTask.Points
+	if task.DailyProgress == "" {
+		task.DailyProgress = existingTask.DailyProgress
+	}
+	task.RRule = existingTask.RRule
+	task.ExDates = existingTask.ExDates
+	task.TimeZone = existingTask.TimeZone
+	task.ReminderOffsets = existingTask.ReminderOffsets
+	task.WorkProgress = existingTask.WorkProgress
+	task.TimeSpent = existingTask.TimeSpent
+	task.ProgressNotes = existingTask.ProgressNotes
+	req.applyOptionalFields(&task)
+
+	// If-Match优先于请求体中的revision：If-Match必须是最新版本，否则412；
+	// 请求体中的revision按字段合并；两者都没有时直接覆盖（旧客户端）
+	ifMatch, err := parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	update := taskService.UpdateTask
+	if ifMatch > 0 {
+		task.Revision = ifMatch
+		update = taskService.UpdateTaskIfMatch
+	}
 
-	// 广播更新
-	broadcastTaskChange("task_updated", &task)
+	if err := update(user.ID, &task); err != nil {
+		var conflict *ConflictError
+		if ifMatch > 0 && errors.As(err, &conflict) {
+			writeConflict(w, http.StatusPreconditionFailed, conflict)
+			return
+		}
+		writeTaskError(w, err)
+		return
+	}
 
+	setETag(w, &task)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(task)
 }
@@ -417,201 +843,35 @@ func deleteTaskHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 获取任务信息用于广播
-	task, err := getTaskByID(id)
+	user := userFromContext(r.Context())
+	task, err := taskService.GetTaskForUser(user.ID, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeTaskError(w, err)
 		return
 	}
 
-	if err := deleteTask(id); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := taskService.DeleteTask(user.ID, task); err != nil {
+		writeTaskError(w, err)
 		return
 	}
 
-	// 广播删除
-	broadcastTaskChange("task_deleted", task)
-
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "任务删除成功"})
 }
 
-// 数据库操作函数
-func getAllTasks(userID string) ([]Task, error) {
-	query := `SELECT id, user_id, title, description,
-	          COALESCE(start_date, '') as start_date,
-	          due_date, is_completed,
-	          COALESCE(category, '学习') as category,
-	          COALESCE(priority, 1) as priority,
-	          device_id, COALESCE(record_id, '') as record_id,
-	          created_at, updated_at,
-	          COALESCE(daily_progress, '{}') as daily_progress
-	          FROM tasks WHERE user_id = ? ORDER BY created_at DESC`
-
-	rows, err := db.Query(query, userID)
-	if err != nil {
-		log.Printf("❌ 数据库查询失败: %v", err)
-		return nil, err
-	}
-	defer rows.Close()
-
-	var tasks []Task
-	for rows.Next() {
-		var task Task
-		err := rows.Scan(
-			&task.ID, &task.UserID, &task.Title, &task.Description,
-			&task.StartDate, &task.DueDate, &task.IsCompleted, &task.Category, &task.Priority,
-			&task.DeviceID, &task.RecordID, &task.CreatedAt, &task.UpdatedAt, &task.DailyProgress,
-		)
-		if err != nil {
-			log.Printf("❌ 扫描任务数据失败: %v", err)
-			continue
-		}
-		tasks = append(tasks, task)
-	}
-
-	log.Printf("✅ 从数据库获取任务数组，任务数量: %d", len(tasks))
-	return tasks, nil
-}
-
-func getTaskByID(id string) (*Task, error) {
-	url := fmt.Sprintf("%s/api/tasks/%s", sqliteAPIURL, id)
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("API请求失败: %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	var task Task
-	err = json.Unmarshal(body, &task)
-	if err != nil {
-		return nil, err
-	}
-
-	return &task, nil
-}
-
-func findDuplicateTask(title, deviceID, userID string) (*Task, error) {
-	// 获取所有任务并在内存中查找重复
-	tasks, err := getAllTasks(userID)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, task := range tasks {
-		if task.Title == title && task.DeviceID == deviceID {
-			return &task, nil
-		}
-	}
-
-	return nil, nil
-}
-
-func createTask(task *Task) (string, error) {
-	jsonData, err := json.Marshal(task)
-	if err != nil {
-		return "", err
-	}
-
-	resp, err := http.Post(sqliteAPIURL+"/api/tasks", "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 && resp.StatusCode != 201 {
-		return "", fmt.Errorf("创建任务失败: %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	var response struct {
-		TaskID string `json:"task_id"`
-		ID     string `json:"id"`
-	}
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		return "", err
-	}
-
-	if response.TaskID != "" {
-		return response.TaskID, nil
-	}
-	return response.ID, nil
-}
-
-func updateTask(task *Task) error {
-	jsonData, err := json.Marshal(task)
-	if err != nil {
-		return err
-	}
-
-	url := fmt.Sprintf("%s/api/tasks/%d", sqliteAPIURL, task.ID)
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("更新任务失败: %d", resp.StatusCode)
-	}
-
-	return nil
-}
-
-func deleteTask(id string) error {
-	url := fmt.Sprintf("%s/api/tasks/%s", sqliteAPIURL, id)
-	req, err := http.NewRequest("DELETE", url, nil)
-	if err != nil {
-		return err
-	}
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("删除任务失败: %d", resp.StatusCode)
-	}
-
-	return nil
-}
-
 // WebSocket消息处理函数
-func handleCreateTask(data interface{}) {
+func handleCreateTask(client *Client, data interface{}) (*Task, error) {
 	log.Printf("📨 收到创建任务消息: %+v", data)
 
 	// 将interface{}转换为Task结构体
 	taskMap, ok := data.(map[string]interface{})
 	if !ok {
-		log.Printf("❌ 创建任务消息格式错误")
-		return
+		return nil, ErrInvalidMessage
 	}
 
 	task := &Task{
-		UserID:        getString(taskMap, "user_id"),
+		ID:            getString(taskMap, "id"),
+		UserID:        getStringWithDefault(taskMap, "assignee_id", client.userID),
 		Title:         getString(taskMap, "title"),
 		Description:   getString(taskMap, "description"),
 		StartDate:     getString(taskMap, "start_date"),
@@ -622,75 +882,126 @@ func handleCreateTask(data interface{}) {
 		DeviceID:      getString(taskMap, "device_id"),
 		RecordID:      getString(taskMap, "record_id"),
 		DailyProgress: getStringWithDefault(taskMap, "daily_progress", "{}"),
+		Points:        getInt(taskMap, "points"),
+		RRule:         getString(taskMap, "rrule"),
+		ExDates:       getString(taskMap, "exdates"),
+		TimeZone:      getString(taskMap, "time_zone"),
+
+		ReminderOffsets: getString(taskMap, "reminder_offsets"),
+		WorkProgress:    getFloat(taskMap, "work_progress"),
+		TimeSpent:       getFloat(taskMap, "time_spent"),
+		ProgressNotes:   getString(taskMap, "progress_notes"),
 	}
 
-	// 通过API创建任务
-	err := createTaskViaAPI(task)
-	if err != nil {
-		log.Printf("❌ 创建任务失败: %v", err)
-		return
+	if err := taskService.CreateTask(client.userID, task); err != nil {
+		return nil, err
 	}
 
 	log.Printf("✅ 任务创建成功，已广播给所有客户端")
+	return task, nil
 }
 
-func handleUpdateTask(data interface{}) {
+func handleUpdateTask(client *Client, data interface{}) (*Task, error) {
 	log.Printf("📨 收到更新任务消息: %+v", data)
 
 	taskMap, ok := data.(map[string]interface{})
 	if !ok {
-		log.Printf("❌ 更新任务消息格式错误")
-		return
+		return nil, ErrInvalidMessage
 	}
 
-	task := &Task{
-		UserID:        getString(taskMap, "user_id"),
-		Title:         getString(taskMap, "title"),
-		Description:   getString(taskMap, "description"),
-		StartDate:     getString(taskMap, "start_date"),
-		DueDate:       getString(taskMap, "due_date"),
-		IsCompleted:   getBool(taskMap, "is_completed"),
-		Category:      getString(taskMap, "category"),
-		Priority:      getInt(taskMap, "priority"),
-		DeviceID:      getString(taskMap, "device_id"),
-		RecordID:      getString(taskMap, "record_id"),
-		DailyProgress: getStringWithDefault(taskMap, "daily_progress", "{}"),
-	}
+	userIDs := visibleUserIDs(client.userID, client.familyID, client.role)
+	recordID := getString(taskMap, "record_id")
+	title := getString(taskMap, "title")
+	deviceID := getString(taskMap, "device_id")
 
-	// 通过API更新任务
-	err := updateTaskViaAPI(task)
+	log.Printf("🔍 查找任务: recordID=%s, title=%s, deviceID=%s", recordID, title, deviceID)
+
+	task, err := taskService.FindTask(userIDs, recordID, title, deviceID)
 	if err != nil {
-		log.Printf("❌ 更新任务失败: %v", err)
-		return
+		log.Printf("❌ 未找到要更新的任务: recordID=%s, title=%s, deviceID=%s (%v)", recordID, title, deviceID, err)
+		return nil, err
+	}
+
+	// 通过record_id定位时允许修改标题和设备
+	if recordID != "" {
+		task.Title = title
+		task.DeviceID = deviceID
+	}
+	task.Description = getString(taskMap, "description")
+	task.DueDate = getString(taskMap, "due_date")
+	task.IsCompleted = getBool(taskMap, "is_completed")
+	task.Category = getString(taskMap, "category")
+	task.Priority = getInt(taskMap, "priority")
+	if _, ok := taskMap["points"]; ok {
+		task.Points = getInt(taskMap, "points")
+	}
+	if _, ok := taskMap["rrule"]; ok {
+		task.RRule = getString(taskMap, "rrule")
+	}
+	if _, ok := taskMap["exdates"]; ok {
+		task.ExDates = getString(taskMap, "exdates")
+	}
+	if _, ok := taskMap["time_zone"]; ok {
+		task.TimeZone = getString(taskMap, "time_zone")
+	}
+	if _, ok := taskMap["reminder_offsets"]; ok {
+		task.ReminderOffsets = getString(taskMap, "reminder_offsets")
+	}
+	if _, ok := taskMap["work_progress"]; ok {
+		task.WorkProgress = getFloat(taskMap, "work_progress")
+	}
+	if _, ok := taskMap["time_spent"]; ok {
+		task.TimeSpent = getFloat(taskMap, "time_spent")
+	}
+	if _, ok := taskMap["progress_notes"]; ok {
+		task.ProgressNotes = getString(taskMap, "progress_notes")
+	}
+	if assigneeID := getString(taskMap, "assignee_id"); assigneeID != "" {
+		task.UserID = assigneeID
+	}
+	// 带base_revision时只有基于最新版本的修改才会保存，否则回复conflict
+	if baseRevision := getInt(taskMap, "base_revision"); baseRevision > 0 {
+		task.Revision = int64(baseRevision)
+	}
+
+	if err := taskService.UpdateTask(client.userID, task); err != nil {
+		return nil, err
 	}
 
 	log.Printf("✅ 任务更新成功，已广播给所有客户端")
+	return task, nil
 }
 
-func handleDeleteTask(data interface{}) {
+func handleDeleteTask(client *Client, data interface{}) (*Task, error) {
 	log.Printf("📨 收到删除任务消息: %+v", data)
 
 	taskMap, ok := data.(map[string]interface{})
 	if !ok {
-		log.Printf("❌ 删除任务消息格式错误")
-		return
+		return nil, ErrInvalidMessage
 	}
 
 	// 优先使用record_id查找任务
+	userIDs := visibleUserIDs(client.userID, client.familyID, client.role)
 	recordID := getString(taskMap, "record_id")
 	title := getString(taskMap, "title")
 	deviceID := getString(taskMap, "device_id") // 修正字段名
 
 	log.Printf("🔍 删除任务参数: recordID=%s, title=%s, deviceID=%s", recordID, title, deviceID)
 
-	// 通过API删除任务
-	err := deleteTaskViaAPI(recordID, title, deviceID)
+	task, err := taskService.FindTask(userIDs, recordID, title, deviceID)
 	if err != nil {
-		log.Printf("❌ 删除任务失败: %v", err)
-		return
+		log.Printf("❌ 未找到要删除的任务: recordID=%s, title=%s, deviceID=%s (%v)", recordID, title, deviceID, err)
+		return nil, err
+	}
+
+	log.Printf("🎯 找到要删除的任务: ID=%v, Title=%s, RecordID=%s", task.ID, task.Title, task.RecordID)
+
+	if err := taskService.DeleteTask(client.userID, task); err != nil {
+		return nil, err
 	}
 
 	log.Printf("✅ 任务删除成功，已广播给所有客户端")
+	return task, nil
 }
 
 // 辅助函数
@@ -765,199 +1076,16 @@ func getInt(m map[string]interface{}, key string) int {
 	return 0
 }
 
-// 数据库操作函数
-func createTaskViaAPI(task *Task) error {
-	// 生成ID如果没有
-	if task.ID == nil || task.ID == "" {
-		task.ID = fmt.Sprintf("task_%d", time.Now().UnixNano())
-	}
-
-	query := `INSERT INTO tasks (id, user_id, title, description, start_date, due_date, is_completed,
-	          category, priority, device_id, record_id, created_at, updated_at, daily_progress)
-	          VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?)`
-
-	_, err := db.Exec(query,
-		task.ID, task.UserID, task.Title, task.Description,
-		task.StartDate, task.DueDate, task.IsCompleted, task.Category, task.Priority,
-		task.DeviceID, task.RecordID, task.DailyProgress)
-
-	if err != nil {
-		log.Printf("❌ 创建任务失败: %v", err)
-		return err
-	}
-
-	log.Printf("✅ 任务创建成功: %s", task.Title)
-
-	// 广播任务创建事件
-	broadcastTaskChange("task_created", task)
-	return nil
-}
-
-func updateTaskViaAPI(task *Task) error {
-	log.Printf("🔍 查找任务: recordID=%s, title=%s, deviceID=%s", task.RecordID, task.Title, task.DeviceID)
-
-	var query string
-	var args []interface{}
-
-	// 优先使用record_id查找任务
-	if task.RecordID != "" {
-		log.Printf("🔍 使用record_id查找任务: %s", task.RecordID)
-		query = `UPDATE tasks SET title=?, description=?, due_date=?, is_completed=?,
-		         category=?, priority=?, device_id=?, updated_at=CURRENT_TIMESTAMP
-		         WHERE record_id=? AND user_id=?`
-		args = []interface{}{
-			task.Title, task.Description, task.DueDate, task.IsCompleted,
-			task.Category, task.Priority, task.DeviceID, task.RecordID, task.UserID,
-		}
-	} else {
-		// 如果没有record_id，使用title和device_id
-		log.Printf("🔍 使用title+device_id查找任务")
-		query = `UPDATE tasks SET description=?, due_date=?, is_completed=?,
-		         category=?, priority=?, updated_at=CURRENT_TIMESTAMP
-		         WHERE title=? AND device_id=? AND user_id=?`
-		args = []interface{}{
-			task.Description, task.DueDate, task.IsCompleted,
-			task.Category, task.Priority, task.Title, task.DeviceID, task.UserID,
-		}
-	}
-
-	result, err := db.Exec(query, args...)
-	if err != nil {
-		log.Printf("❌ 更新任务失败: %v", err)
-		return err
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		log.Printf("❌ 获取影响行数失败: %v", err)
-		return err
-	}
-
-	if rowsAffected == 0 {
-		log.Printf("❌ 未找到要更新的任务: recordID=%s, title=%s, deviceID=%s", task.RecordID, task.Title, task.DeviceID)
-		return fmt.Errorf("未找到要更新的任务")
-	}
-
-	log.Printf("✅ 任务更新成功: %s (影响行数: %d)", task.Title, rowsAffected)
-
-	// 获取更新后的任务数据用于广播
-	var updatedTask Task
-	var selectQuery string
-	var selectArgs []interface{}
-
-	if task.RecordID != "" {
-		selectQuery = `SELECT id, user_id, title, description, due_date, is_completed,
-		               COALESCE(category, '学习') as category,
-		               COALESCE(priority, 1) as priority,
-		               device_id, COALESCE(record_id, '') as record_id,
-		               created_at, updated_at
-		               FROM tasks WHERE record_id=? AND user_id=?`
-		selectArgs = []interface{}{task.RecordID, task.UserID}
-	} else {
-		selectQuery = `SELECT id, user_id, title, description, due_date, is_completed,
-		               COALESCE(category, '学习') as category,
-		               COALESCE(priority, 1) as priority,
-		               device_id, COALESCE(record_id, '') as record_id,
-		               created_at, updated_at
-		               FROM tasks WHERE title=? AND device_id=? AND user_id=?`
-		selectArgs = []interface{}{task.Title, task.DeviceID, task.UserID}
-	}
-
-	err = db.QueryRow(selectQuery, selectArgs...).Scan(
-		&updatedTask.ID, &updatedTask.UserID, &updatedTask.Title, &updatedTask.Description,
-		&updatedTask.DueDate, &updatedTask.IsCompleted, &updatedTask.Category, &updatedTask.Priority,
-		&updatedTask.DeviceID, &updatedTask.RecordID, &updatedTask.CreatedAt, &updatedTask.UpdatedAt,
-	)
-
-	if err != nil {
-		log.Printf("❌ 获取更新后的任务数据失败: %v", err)
-		// 即使获取失败，也使用原始数据广播
-		updatedTask = *task
-	}
-
-	// 广播任务更新事件
-	broadcastTaskChange("task_updated", &updatedTask)
-	return nil
-}
-
-func deleteTaskViaAPI(recordID, title, deviceID string) error {
-	log.Printf("🔍 查找要删除的任务: recordID=%s, title=%s, deviceID=%s", recordID, title, deviceID)
-
-	var query string
-	var args []interface{}
-	var targetTask Task
-
-	// 优先使用recordID查找任务
-	if recordID != "" {
-		log.Printf("🔍 使用record_id查找任务: %s", recordID)
-		query = `SELECT id, user_id, title, description, due_date, is_completed,
-		         COALESCE(category, '学习') as category,
-		         COALESCE(priority, 1) as priority,
-		         device_id, COALESCE(record_id, '') as record_id,
-		         created_at, updated_at
-		         FROM tasks WHERE record_id=?`
-		args = []interface{}{recordID}
-	} else {
-		// 如果没有recordID，使用title和deviceID
-		log.Printf("🔍 使用title+device_id查找任务")
-		query = `SELECT id, user_id, title, description, due_date, is_completed,
-		         COALESCE(category, '学习') as category,
-		         COALESCE(priority, 1) as priority,
-		         device_id, COALESCE(record_id, '') as record_id,
-		         created_at, updated_at
-		         FROM tasks WHERE title=? AND device_id=?`
-		args = []interface{}{title, deviceID}
-	}
-
-	err := db.QueryRow(query, args...).Scan(
-		&targetTask.ID, &targetTask.UserID, &targetTask.Title, &targetTask.Description,
-		&targetTask.DueDate, &targetTask.IsCompleted, &targetTask.Category, &targetTask.Priority,
-		&targetTask.DeviceID, &targetTask.RecordID, &targetTask.CreatedAt, &targetTask.UpdatedAt,
-	)
-
-	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("❌ 未找到要删除的任务: recordID=%s, title=%s, deviceID=%s", recordID, title, deviceID)
-			return fmt.Errorf("未找到要删除的任务")
+func getFloat(m map[string]interface{}, key string) float64 {
+	if val, ok := m[key]; ok {
+		switch v := val.(type) {
+		case float64:
+			return v
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f
+			}
 		}
-		log.Printf("❌ 查询任务失败: %v", err)
-		return err
-	}
-
-	log.Printf("🎯 找到要删除的任务: ID=%v, Title=%s, RecordID=%s", targetTask.ID, targetTask.Title, targetTask.RecordID)
-
-	// 执行删除
-	var deleteQuery string
-	var deleteArgs []interface{}
-
-	if recordID != "" {
-		deleteQuery = "DELETE FROM tasks WHERE record_id=?"
-		deleteArgs = []interface{}{recordID}
-	} else {
-		deleteQuery = "DELETE FROM tasks WHERE title=? AND device_id=?"
-		deleteArgs = []interface{}{title, deviceID}
 	}
-
-	result, err := db.Exec(deleteQuery, deleteArgs...)
-	if err != nil {
-		log.Printf("❌ 删除任务失败: %v", err)
-		return err
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		log.Printf("❌ 获取影响行数失败: %v", err)
-		return err
-	}
-
-	if rowsAffected == 0 {
-		log.Printf("❌ 删除任务失败，没有行被影响")
-		return fmt.Errorf("删除任务失败")
-	}
-
-	log.Printf("🗑️ 任务删除成功: %s (影响行数: %d)", targetTask.Title, rowsAffected)
-
-	// 广播任务删除事件
-	broadcastTaskChange("task_deleted", &targetTask)
-	return nil
+	return 0
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// 测试时不输出迁移和任务操作的日志
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// 为测试打开一个执行过全部迁移的临时数据库，替换全局的db和taskService
+func openTestDB(t *testing.T) {
+	t.Helper()
+	testDB, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateOnStartup(testDB); err != nil {
+		t.Fatal(err)
+	}
+	// 广播只放入队列，不启动Hub；需要检查广播的测试直接读取hub.broadcast
+	previousDB, previousService, previousHub, previousJobs := db, taskService, hub, listJobs
+	db, taskService = testDB, NewTaskService(NewSQLiteTaskStore(testDB))
+	hub = &Hub{broadcast: make(chan Broadcast, 1024)}
+	listJobs = make(chan listJob, 1024)
+	t.Cleanup(func() {
+		db, taskService, hub, listJobs = previousDB, previousService, previousHub, previousJobs
+		testDB.Close()
+	})
+}
+
+// 创建任务并返回保存后的内容
+func createTestTask(t *testing.T, actorID string, task Task) *Task {
+	t.Helper()
+	if err := taskService.CreateTask(actorID, &task); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := taskService.store.Get(getTaskIDString(&task))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return saved
+}
+
+// 把用户加入家庭
+func addTestMember(t *testing.T, familyID, userID, role string) {
+	t.Helper()
+	if _, err := db.Exec("INSERT INTO family_members (family_id, user_id, role) VALUES (?, ?, ?)", familyID, userID, role); err != nil {
+		t.Fatal(err)
+	}
+}
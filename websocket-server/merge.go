@@ -0,0 +1,139 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+)
+
+// field_revisions中表示"其余所有字段"的键，开始记录字段版本之前的修改都算在这个版本上
+const allFields = "*"
+
+// 保留的历史版本数，基础版本比这更旧的修改只能按冲突处理
+const taskHistoryLimit = 100
+
+// 可以合并的字段。device_id/record_id是设备相关的定位信息，审批字段由审批流程维护，都不参与合并
+type taskField struct {
+	name string
+	get  func(t *Task) interface{}
+	set  func(dst, src *Task)
+}
+
+var mergeFields = []taskField{
+	{"user_id", func(t *Task) interface{} { return t.UserID }, func(d, s *Task) { d.UserID = s.UserID }},
+	{"title", func(t *Task) interface{} { return t.Title }, func(d, s *Task) { d.Title = s.Title }},
+	{"description", func(t *Task) interface{} { return t.Description }, func(d, s *Task) { d.Description = s.Description }},
+	{"start_date", func(t *Task) interface{} { return t.StartDate }, func(d, s *Task) { d.StartDate = s.StartDate }},
+	{"due_date", func(t *Task) interface{} { return t.DueDate }, func(d, s *Task) { d.DueDate = s.DueDate }},
+	{"is_completed", func(t *Task) interface{} { return t.IsCompleted }, func(d, s *Task) { d.IsCompleted = s.IsCompleted }},
+	{"category", func(t *Task) interface{} { return t.Category }, func(d, s *Task) { d.Category = s.Category }},
+	{"priority", func(t *Task) interface{} { return t.Priority }, func(d, s *Task) { d.Priority = s.Priority }},
+	{"daily_progress", func(t *Task) interface{} { return t.DailyProgress }, func(d, s *Task) { d.DailyProgress = s.DailyProgress }},
+	{"points", func(t *Task) interface{} { return t.Points }, func(d, s *Task) { d.Points = s.Points }},
+	{"rrule", func(t *Task) interface{} { return t.RRule }, func(d, s *Task) { d.RRule = s.RRule }},
+	{"exdates", func(t *Task) interface{} { return t.ExDates }, func(d, s *Task) { d.ExDates = s.ExDates }},
+	{"time_zone", func(t *Task) interface{} { return t.TimeZone }, func(d, s *Task) { d.TimeZone = s.TimeZone }},
+	{"reminder_offsets", func(t *Task) interface{} { return t.ReminderOffsets }, func(d, s *Task) { d.ReminderOffsets = s.ReminderOffsets }},
+	{"work_progress", func(t *Task) interface{} { return t.WorkProgress }, func(d, s *Task) { d.WorkProgress = s.WorkProgress }},
+	{"time_spent", func(t *Task) interface{} { return t.TimeSpent }, func(d, s *Task) { d.TimeSpent = s.TimeSpent }},
+	{"progress_notes", func(t *Task) interface{} { return t.ProgressNotes }, func(d, s *Task) { d.ProgressNotes = s.ProgressNotes }},
+}
+
+// 字段最后一次修改时的版本号
+func (t *Task) fieldRevision(name string) int64 {
+	if revision, ok := t.FieldRevisions[name]; ok {
+		return revision
+	}
+	if revision, ok := t.FieldRevisions[allFields]; ok {
+		return revision
+	}
+	// 没有记录时只能认为刚刚修改过
+	return t.Revision
+}
+
+// 保存updated时的字段版本：与current相比变化的字段记为新版本revision
+func changedFieldRevisions(current, updated *Task, revision int64) map[string]int64 {
+	revisions := map[string]int64{allFields: current.fieldRevision(allFields)}
+	for name, rev := range current.FieldRevisions {
+		revisions[name] = rev
+	}
+	for _, field := range mergeFields {
+		if field.get(current) != field.get(updated) {
+			revisions[field.name] = revision
+		}
+	}
+	return revisions
+}
+
+// 保存任务的历史版本，只保留最近taskHistoryLimit个
+func saveTaskHistory(tx *sql.Tx, task *Task) error {
+	snapshot, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO task_history (task_id, revision, snapshot) VALUES (?, ?, ?)`,
+		getTaskIDString(task), task.Revision, string(snapshot)); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM task_history WHERE task_id = ? AND revision <= ?`,
+		getTaskIDString(task), task.Revision-taskHistoryLimit)
+	return err
+}
+
+// GetRevision 任务在某个版本时的内容。删除/恢复只改变版本号不保存快照，
+// 所以取不超过该版本的最近一个快照；没有历史时返回ErrTaskNotFound
+func (s *SQLiteTaskStore) GetRevision(id string, revision int64) (*Task, error) {
+	var snapshot string
+	err := s.db.QueryRow(`SELECT snapshot FROM task_history WHERE task_id = ? AND revision <= ?
+		ORDER BY revision DESC LIMIT 1`, id, revision).Scan(&snapshot)
+	if err == sql.ErrNoRows {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var task Task
+	if err := json.Unmarshal([]byte(snapshot), &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// 把基于旧版本的修改合并到最新版本上（三方合并）：
+// 服务器在基础版本之后没有改过的字段直接使用客户端的值；
+// 服务器改过而客户端没改的字段保留服务器的值；两边都改成不同的值才算冲突
+func (s *TaskService) mergeConcurrentEdit(current, updated *Task) error {
+	baseRevision := updated.Revision
+	if baseRevision > current.Revision {
+		return &ConflictError{Current: current}
+	}
+
+	var base *Task
+	var conflicts []string
+	for _, field := range mergeFields {
+		if field.get(updated) == field.get(current) || current.fieldRevision(field.name) <= baseRevision {
+			continue
+		}
+		if base == nil {
+			var err error
+			if base, err = s.store.GetRevision(getTaskIDString(current), baseRevision); err == ErrTaskNotFound {
+				// 没有基础版本的快照，无法判断客户端是否改过
+				return &ConflictError{Current: current}
+			} else if err != nil {
+				return err
+			}
+		}
+		if field.get(updated) == field.get(base) {
+			field.set(updated, current)
+			continue
+		}
+		conflicts = append(conflicts, field.name)
+	}
+	if len(conflicts) > 0 {
+		return &ConflictError{Current: current, Fields: conflicts}
+	}
+
+	log.Printf("🔀 合并并发修改: %s 基础版本=%d 当前版本=%d", current.Title, baseRevision, current.Revision)
+	updated.Revision = current.Revision
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestFieldRevision(t *testing.T) {
+	task := &Task{Revision: 7, FieldRevisions: map[string]int64{allFields: 2, "title": 5}}
+	if got := task.fieldRevision("title"); got != 5 {
+		t.Errorf("title = %d, want 5", got)
+	}
+	if got := task.fieldRevision("description"); got != 2 {
+		t.Errorf("没有单独记录的字段应该取*的版本: %d", got)
+	}
+	if got := (&Task{Revision: 7}).fieldRevision("title"); got != 7 {
+		t.Errorf("没有字段版本时应该取任务版本: %d", got)
+	}
+}
+
+func TestChangedFieldRevisions(t *testing.T) {
+	current := &Task{Title: "a", Description: "d", Points: 1, Revision: 4,
+		FieldRevisions: map[string]int64{allFields: 1, "title": 3}}
+	updated := *current
+	updated.Description = "d2"
+	updated.Points = 2
+
+	got := changedFieldRevisions(current, &updated, 5)
+	want := map[string]int64{allFields: 1, "title": 3, "description": 5, "points": 5}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changedFieldRevisions() = %v, want %v", got, want)
+	}
+}
+
+// 每个可合并字段的set都要复制get读取的那个字段
+func TestMergeFieldsCopyTheirField(t *testing.T) {
+	src := &Task{UserID: "u", Title: "t", Description: "d", StartDate: "2026-10-01", DueDate: "2026-10-02",
+		IsCompleted: true, Category: "学习", Priority: 2, DailyProgress: "{}", Points: 3, RRule: "FREQ=DAILY",
+		ExDates: "20261003", TimeZone: "+08:00", ReminderOffsets: "10", WorkProgress: 50, TimeSpent: 1.5, ProgressNotes: "n"}
+	for _, field := range mergeFields {
+		dst := &Task{}
+		if field.get(dst) == field.get(src) {
+			t.Fatalf("%s: 测试数据的字段不能是零值", field.name)
+		}
+		field.set(dst, src)
+		if field.get(dst) != field.get(src) {
+			t.Errorf("%s: set没有复制字段", field.name)
+		}
+		for _, other := range mergeFields {
+			if other.name != field.name && other.get(dst) == other.get(src) {
+				t.Errorf("%s: set还修改了%s", field.name, other.name)
+			}
+		}
+	}
+}
+
+func TestUpdateTaskMergesConcurrentEdits(t *testing.T) {
+	tests := []struct {
+		name       string
+		edit       func(task *Task)
+		ifMatch    bool
+		baseOffset int64
+		wantFields []string
+		conflict   bool
+		check      func(t *testing.T, saved *Task)
+	}{
+		{
+			name: "修改不同字段时合并",
+			edit: func(task *Task) { task.Description = "客户端的描述" },
+			check: func(t *testing.T, saved *Task) {
+				if saved.Title != "服务器的标题" || saved.Description != "客户端的描述" {
+					t.Errorf("合并结果 = %s / %s", saved.Title, saved.Description)
+				}
+			},
+		},
+		{
+			name: "没有修改任何字段时保留服务器的修改",
+			edit: func(task *Task) {},
+			check: func(t *testing.T, saved *Task) {
+				if saved.Title != "服务器的标题" {
+					t.Errorf("标题 = %s", saved.Title)
+				}
+			},
+		},
+		{
+			name: "两边改成相同的值不算冲突",
+			edit: func(task *Task) { task.Title = "服务器的标题"; task.Priority = 3 },
+			check: func(t *testing.T, saved *Task) {
+				if saved.Priority != 3 {
+					t.Errorf("优先级 = %d", saved.Priority)
+				}
+			},
+		},
+		{
+			name:       "两边修改同一字段时冲突",
+			edit:       func(task *Task) { task.Title = "客户端的标题"; task.Description = "客户端的描述" },
+			conflict:   true,
+			wantFields: []string{"title"},
+		},
+		{
+			name:     "If-Match不是最新版本时直接冲突，不合并",
+			edit:     func(task *Task) { task.Description = "客户端的描述" },
+			ifMatch:  true,
+			conflict: true,
+		},
+		{
+			name:       "基础版本比服务器新时冲突",
+			edit:       func(task *Task) { task.Description = "客户端的描述" },
+			baseOffset: 10,
+			conflict:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			base := createTestTask(t, "solo", Task{Title: "标题", Description: "描述", Priority: 1})
+
+			server := *base
+			server.Title = "服务器的标题"
+			if err := taskService.UpdateTask("solo", &server); err != nil {
+				t.Fatal(err)
+			}
+
+			client := *base
+			client.Revision += tt.baseOffset
+			tt.edit(&client)
+			var err error
+			if tt.ifMatch {
+				err = taskService.UpdateTaskIfMatch("solo", &client)
+			} else {
+				err = taskService.UpdateTask("solo", &client)
+			}
+
+			var conflict *ConflictError
+			if tt.conflict {
+				if !errors.As(err, &conflict) {
+					t.Fatalf("UpdateTask() = %v, want ConflictError", err)
+				}
+				if conflict.Current.Revision != server.Revision || conflict.Current.Title != "服务器的标题" {
+					t.Errorf("冲突应该带上服务器当前的任务: %+v", conflict.Current)
+				}
+				if !reflect.DeepEqual(conflict.Fields, tt.wantFields) {
+					t.Errorf("冲突字段 = %v, want %v", conflict.Fields, tt.wantFields)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UpdateTask() = %v", err)
+			}
+			saved, err := taskService.store.Get(getTaskIDString(base))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved.Revision != server.Revision+1 {
+				t.Errorf("版本 = %d, want %d", saved.Revision, server.Revision+1)
+			}
+			tt.check(t, saved)
+		})
+	}
+}
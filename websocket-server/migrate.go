@@ -0,0 +1,305 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 迁移文件命名: NNNN_name.up.sql / NNNN_name.down.sql
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration 一个版本的数据库结构变更
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus 某个迁移在当前数据库中的状态
+type MigrationStatus struct {
+	Migration
+	AppliedAt string
+}
+
+func loadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("迁移文件名格式错误: %s", name)
+		}
+
+		content, err := migrationFiles.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		} else if m.Name != parts[1] {
+			return nil, fmt.Errorf("迁移版本 %d 名称冲突: %s / %s", version, m.Name, parts[1])
+		}
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("迁移 %04d_%s 缺少up或down文件", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("迁移版本不连续: 期望 %d, 实际 %d", i+1, m.Version)
+		}
+	}
+	return migrations, nil
+}
+
+// Migrator 按版本顺序执行嵌入在二进制中的迁移
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Latest 二进制中包含的最新版本
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// CurrentVersion 数据库当前版本，未执行过迁移时为0
+func (m *Migrator) CurrentVersion() (int, error) {
+	var version int
+	err := m.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
+
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied := map[int]string{}
+	rows, err := m.db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		statuses = append(statuses, MigrationStatus{Migration: migration, AppliedAt: applied[migration.Version]})
+	}
+	return statuses, nil
+}
+
+// Up 执行所有未执行的迁移
+func (m *Migrator) Up() error {
+	return m.To(m.Latest())
+}
+
+// Down 回滚最近一个迁移
+func (m *Migrator) Down() error {
+	current, err := m.CurrentVersion()
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		return nil
+	}
+	return m.To(current - 1)
+}
+
+// To 迁移到指定版本，可向上也可向下
+func (m *Migrator) To(target int) error {
+	if target < 0 || target > m.Latest() {
+		return fmt.Errorf("目标版本 %d 超出范围 [0, %d]", target, m.Latest())
+	}
+
+	current, err := m.CurrentVersion()
+	if err != nil {
+		return err
+	}
+	if current > m.Latest() {
+		return fmt.Errorf("数据库版本 %d 高于程序支持的版本 %d", current, m.Latest())
+	}
+
+	for current < target {
+		next := m.migrations[current]
+		if err := m.apply(next, true); err != nil {
+			return err
+		}
+		current++
+	}
+	for current > target {
+		prev := m.migrations[current-1]
+		if err := m.apply(prev, false); err != nil {
+			return err
+		}
+		current--
+	}
+	return nil
+}
+
+// 在同一个事务中执行迁移SQL并更新schema_migrations
+func (m *Migrator) apply(migration Migration, up bool) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	script, direction := migration.Down, "down"
+	if up {
+		script, direction = migration.Up, "up"
+	}
+	if _, err := tx.Exec(script); err != nil {
+		return fmt.Errorf("迁移 %04d_%s (%s) 失败: %w", migration.Version, migration.Name, direction, err)
+	}
+
+	if up {
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+			migration.Version, migration.Name, time.Now().UTC().Format(timeLayout))
+	} else {
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("📦 迁移 %04d_%s (%s) 完成", migration.Version, migration.Name, direction)
+	return nil
+}
+
+// 启动时检查并执行迁移；数据库版本高于程序时拒绝启动
+func migrateOnStartup(db *sql.DB) error {
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	current, err := migrator.CurrentVersion()
+	if err != nil {
+		return err
+	}
+	if current > migrator.Latest() {
+		return fmt.Errorf("数据库版本 %d 高于程序支持的版本 %d，请使用更新版本的程序", current, migrator.Latest())
+	}
+	return migrator.Up()
+}
+
+// migrate子命令: status | up | down | to N
+func runMigrateCommand(db *sql.DB, args []string) error {
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		args = []string{"status"}
+	}
+
+	switch args[0] {
+	case "status":
+		current, err := migrator.CurrentVersion()
+		if err != nil {
+			return err
+		}
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("当前版本: %d, 最新版本: %d\n", current, migrator.Latest())
+		for _, s := range statuses {
+			state := "pending"
+			if s.AppliedAt != "" {
+				state = "applied " + s.AppliedAt
+			}
+			fmt.Printf("  %04d_%-30s %s\n", s.Version, s.Name, state)
+		}
+		if current > migrator.Latest() {
+			fmt.Printf("⚠️ 数据库版本高于程序支持的版本\n")
+		}
+		return nil
+	case "up":
+		return migrator.Up()
+	case "down":
+		return migrator.Down()
+	case "to":
+		if len(args) < 2 {
+			return fmt.Errorf("用法: migrate to <版本号>")
+		}
+		target, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("无效的版本号: %s", args[1])
+		}
+		return migrator.To(target)
+	default:
+		return fmt.Errorf("未知的migrate命令: %s (可用: status, up, down, to N)", args[0])
+	}
+}
@@ -0,0 +1,18 @@
+-- 任务表。旧版本通过启动时的ALTER TABLE循环补齐了category/priority/record_id/
+-- start_date/daily_progress字段，所以已有数据库在这里是空操作
+CREATE TABLE IF NOT EXISTS tasks (
+	id TEXT PRIMARY KEY,
+	user_id TEXT DEFAULT 'default_user',
+	title TEXT NOT NULL,
+	description TEXT,
+	start_date TEXT,
+	due_date TEXT,
+	is_completed INTEGER DEFAULT 0,
+	category TEXT DEFAULT '学习',
+	priority INTEGER DEFAULT 1,
+	device_id TEXT,
+	record_id TEXT,
+	created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+	updated_at TEXT DEFAULT CURRENT_TIMESTAMP,
+	daily_progress TEXT DEFAULT '{}'
+);
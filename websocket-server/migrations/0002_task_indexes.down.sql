@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_tasks_record_id;
+DROP INDEX IF EXISTS idx_tasks_user_created;
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_tasks_user_created ON tasks (user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_tasks_record_id ON tasks (record_id);
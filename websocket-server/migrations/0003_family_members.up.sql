@@ -0,0 +1,8 @@
+-- 家庭/群组成员关系，用于决定WebSocket广播的接收范围
+CREATE TABLE IF NOT EXISTS family_members (
+	family_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	joined_at TEXT DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (family_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_family_members_user ON family_members (user_id);
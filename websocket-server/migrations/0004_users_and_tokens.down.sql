@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_auth_tokens_user;
+DROP TABLE IF EXISTS auth_tokens;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	username TEXT NOT NULL UNIQUE,
+	password_hash TEXT NOT NULL,
+	display_name TEXT DEFAULT '',
+	created_at TEXT DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 只保存token的SHA-256摘要，明文token只在登录时返回一次
+CREATE TABLE IF NOT EXISTS auth_tokens (
+	token_hash TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+	expires_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_auth_tokens_user ON auth_tokens (user_id);
@@ -0,0 +1,3 @@
+ALTER TABLE tasks DROP COLUMN created_by;
+ALTER TABLE family_members DROP COLUMN role;
+DROP TABLE IF EXISTS families;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS families (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	created_by TEXT NOT NULL,
+	created_at TEXT DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 成员角色: parent 可以为家庭成员创建/分配/删除任务; child 只能完成自己的任务或更新每日进度
+ALTER TABLE family_members ADD COLUMN role TEXT NOT NULL DEFAULT 'child';
+
+-- 任务的创建者（家长为孩子分配任务时与user_id不同）
+ALTER TABLE tasks ADD COLUMN created_by TEXT DEFAULT '';
@@ -0,0 +1,5 @@
+ALTER TABLE tasks DROP COLUMN reviewed_at;
+ALTER TABLE tasks DROP COLUMN reviewed_by;
+ALTER TABLE tasks DROP COLUMN completion_requested_at;
+ALTER TABLE tasks DROP COLUMN approval_comment;
+ALTER TABLE tasks DROP COLUMN approval_status;
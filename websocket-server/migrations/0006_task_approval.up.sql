@@ -0,0 +1,6 @@
+-- 孩子完成任务后的审批流程: '' -> pending -> approved / rejected
+ALTER TABLE tasks ADD COLUMN approval_status TEXT DEFAULT '';
+ALTER TABLE tasks ADD COLUMN approval_comment TEXT DEFAULT '';
+ALTER TABLE tasks ADD COLUMN completion_requested_at TEXT DEFAULT '';
+ALTER TABLE tasks ADD COLUMN reviewed_by TEXT DEFAULT '';
+ALTER TABLE tasks ADD COLUMN reviewed_at TEXT DEFAULT '';
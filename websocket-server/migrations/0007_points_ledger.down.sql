@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_points_ledger_task;
+DROP INDEX IF EXISTS idx_points_ledger_user;
+DROP TABLE IF EXISTS points_ledger;
+ALTER TABLE tasks DROP COLUMN points;
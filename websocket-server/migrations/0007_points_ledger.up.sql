@@ -0,0 +1,16 @@
+-- 任务奖励积分
+ALTER TABLE tasks ADD COLUMN points INTEGER DEFAULT 0;
+
+-- 积分流水: 任务完成入账为正，撤销完成和兑换奖励为负，余额为delta之和
+CREATE TABLE IF NOT EXISTS points_ledger (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id TEXT NOT NULL,
+	delta INTEGER NOT NULL,
+	kind TEXT NOT NULL,
+	reason TEXT DEFAULT '',
+	task_id TEXT DEFAULT '',
+	created_by TEXT DEFAULT '',
+	created_at TEXT DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_points_ledger_user ON points_ledger (user_id, id);
+CREATE INDEX IF NOT EXISTS idx_points_ledger_task ON points_ledger (task_id);
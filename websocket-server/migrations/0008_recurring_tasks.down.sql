@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS task_occurrences;
+ALTER TABLE tasks DROP COLUMN exdates;
+ALTER TABLE tasks DROP COLUMN rrule;
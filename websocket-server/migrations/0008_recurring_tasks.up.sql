@@ -0,0 +1,17 @@
+-- 重复规则 (RFC 5545 RRULE子集) 和排除日期（逗号分隔的YYYY-MM-DD）
+ALTER TABLE tasks ADD COLUMN rrule TEXT DEFAULT '';
+ALTER TABLE tasks ADD COLUMN exdates TEXT DEFAULT '';
+
+-- 重复任务按单次完成，不影响整个系列
+CREATE TABLE IF NOT EXISTS task_occurrences (
+	task_id TEXT NOT NULL,
+	occurrence_date TEXT NOT NULL,
+	is_completed INTEGER DEFAULT 0,
+	approval_status TEXT DEFAULT '',
+	approval_comment TEXT DEFAULT '',
+	completed_by TEXT DEFAULT '',
+	completed_at TEXT DEFAULT '',
+	reviewed_by TEXT DEFAULT '',
+	reviewed_at TEXT DEFAULT '',
+	PRIMARY KEY (task_id, occurrence_date)
+);
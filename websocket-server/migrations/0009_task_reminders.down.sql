@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS reminders_sent;
+ALTER TABLE tasks DROP COLUMN reminder_offsets;
@@ -0,0 +1,14 @@
+-- 提醒偏移（逗号分隔的提前分钟数，可加start:/due:前缀指定基准时间）
+ALTER TABLE tasks ADD COLUMN reminder_offsets TEXT DEFAULT '';
+
+-- 已发送的提醒，保证每个提醒只发送一次，重启后不会重复发送
+CREATE TABLE IF NOT EXISTS reminders_sent (
+	task_id TEXT NOT NULL,
+	anchor TEXT NOT NULL,
+	remind_at TEXT NOT NULL,
+	offset_minutes INTEGER NOT NULL,
+	occurrence_date TEXT DEFAULT '',
+	sent_at TEXT DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (task_id, anchor, remind_at)
+);
+CREATE INDEX IF NOT EXISTS idx_reminders_sent_sent_at ON reminders_sent (sent_at);
@@ -0,0 +1,13 @@
+-- 幂等键: 同一设备重试同一个请求时返回第一次的结果，不重复执行
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	user_id TEXT NOT NULL,
+	device_id TEXT NOT NULL,
+	idempotency_key TEXT NOT NULL,
+	fingerprint TEXT NOT NULL,
+	status_code INTEGER NOT NULL DEFAULT 0,
+	content_type TEXT DEFAULT '',
+	response TEXT DEFAULT '',
+	created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, device_id, idempotency_key)
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created ON idempotency_keys (created_at);
@@ -0,0 +1,30 @@
+-- 每个用户一个单调递增的变更序号，家长会收到家庭成员任务的变更
+CREATE TABLE IF NOT EXISTS change_sequences (
+	user_id TEXT PRIMARY KEY,
+	seq INTEGER NOT NULL DEFAULT 0
+);
+
+-- 变更日志: 增量同步时按游标返回之后变化过的任务，delete为墓碑
+CREATE TABLE IF NOT EXISTS task_changes (
+	user_id TEXT NOT NULL,
+	seq INTEGER NOT NULL,
+	task_id TEXT NOT NULL,
+	change_type TEXT NOT NULL,
+	created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, seq)
+);
+CREATE INDEX IF NOT EXISTS idx_task_changes_task ON task_changes (user_id, task_id, seq);
+
+-- 已有的任务记为一次upsert，since=0即可取到全部任务
+WITH viewers AS (
+	SELECT t.id AS task_id, t.user_id AS viewer, t.created_at FROM tasks t
+	UNION
+	SELECT t.id, p.user_id, t.created_at FROM tasks t
+	JOIN family_members m ON m.user_id = t.user_id
+	JOIN family_members p ON p.family_id = m.family_id AND p.role = 'parent'
+)
+INSERT INTO task_changes (user_id, seq, task_id, change_type)
+SELECT viewer, ROW_NUMBER() OVER (PARTITION BY viewer ORDER BY created_at, task_id), task_id, 'upsert' FROM viewers;
+
+INSERT INTO change_sequences (user_id, seq)
+SELECT user_id, MAX(seq) FROM task_changes GROUP BY user_id;
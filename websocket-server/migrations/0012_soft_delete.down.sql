@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_tasks_user_deleted;
+DELETE FROM tasks WHERE COALESCE(deleted_at, '') != '';
+ALTER TABLE tasks DROP COLUMN deleted_by;
+ALTER TABLE tasks DROP COLUMN deleted_at;
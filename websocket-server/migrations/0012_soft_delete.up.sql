@@ -0,0 +1,4 @@
+-- 软删除: 删除的任务保留为墓碑，可以在回收站恢复，保留期过后由清理任务彻底删除
+ALTER TABLE tasks ADD COLUMN deleted_at TEXT DEFAULT '';
+ALTER TABLE tasks ADD COLUMN deleted_by TEXT DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_tasks_user_deleted ON tasks (user_id, deleted_at);
@@ -0,0 +1,2 @@
+-- 乐观并发控制: 每次修改任务revision加1，客户端带着基础版本修改，不一致时返回冲突
+ALTER TABLE tasks ADD COLUMN revision INTEGER DEFAULT 1;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS task_history;
+ALTER TABLE tasks DROP COLUMN field_revisions;
@@ -0,0 +1,12 @@
+-- 每个字段最后一次修改时的版本号(JSON)，"*"为开始记录之前所有字段的版本
+ALTER TABLE tasks ADD COLUMN field_revisions TEXT DEFAULT '{}';
+UPDATE tasks SET field_revisions = json_object('*', COALESCE(revision, 1));
+
+-- 任务的历史版本，合并并发修改时用来取得客户端的基础版本
+CREATE TABLE IF NOT EXISTS task_history (
+	task_id TEXT NOT NULL,
+	revision INTEGER NOT NULL,
+	snapshot TEXT NOT NULL,
+	created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (task_id, revision)
+);
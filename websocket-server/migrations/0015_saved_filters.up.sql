@@ -0,0 +1,12 @@
+-- 智能列表: 用户保存的筛选表达式，查看时按当前时间求值
+CREATE TABLE IF NOT EXISTS saved_filters (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	query TEXT NOT NULL,
+	sort TEXT DEFAULT '',
+	time_zone TEXT DEFAULT '',
+	created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+	updated_at TEXT DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_saved_filters_user ON saved_filters (user_id);
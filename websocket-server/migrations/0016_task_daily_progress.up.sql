@@ -0,0 +1,38 @@
+-- 每日进度: 每个任务每天一行，取代tasks.daily_progress中的JSON字符串。
+-- tasks.daily_progress保留给旧客户端，内容由这张表生成
+CREATE TABLE IF NOT EXISTS task_daily_progress (
+	task_id TEXT NOT NULL,
+	date TEXT NOT NULL,
+	progress INTEGER DEFAULT 0,
+	minutes_spent INTEGER DEFAULT 0,
+	note TEXT DEFAULT '',
+	updated_by TEXT DEFAULT '',
+	updated_at TEXT DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (task_id, date)
+);
+
+-- 迁移已有的JSON: 键为日期，值可以是对象{progress, minutes_spent, note}、百分比数字、完成标记或备注文字
+INSERT OR IGNORE INTO task_daily_progress (task_id, date, progress, minutes_spent, note, updated_at)
+SELECT t.id, substr(p.key, 1, 10),
+	MAX(0, MIN(100, CAST(ROUND(CASE p.type
+		WHEN 'object' THEN COALESCE(json_extract(p.value, '$.progress'), json_extract(p.value, '$.percent'), 0)
+		WHEN 'integer' THEN p.value
+		WHEN 'real' THEN p.value
+		WHEN 'true' THEN 100
+		ELSE 0 END) AS INTEGER))),
+	CASE p.type
+		WHEN 'object' THEN MAX(0, CAST(COALESCE(json_extract(p.value, '$.minutes_spent'), json_extract(p.value, '$.minutes'), 0) AS INTEGER))
+		ELSE 0 END,
+	CASE p.type
+		WHEN 'object' THEN COALESCE(json_extract(p.value, '$.note'), json_extract(p.value, '$.notes'), '')
+		WHEN 'text' THEN p.value
+		ELSE '' END,
+	COALESCE(NULLIF(t.updated_at, ''), CURRENT_TIMESTAMP)
+FROM tasks t, json_each(CASE WHEN json_valid(t.daily_progress) AND json_type(t.daily_progress) = 'object' THEN t.daily_progress ELSE '{}' END) p
+WHERE p.key GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]*';
+
+-- 按新格式重新生成旧字段，与服务端之后写入的格式一致
+UPDATE tasks SET daily_progress = (
+	SELECT json_group_object(date, json_object('progress', progress, 'minutes_spent', minutes_spent, 'note', note))
+	FROM (SELECT * FROM task_daily_progress WHERE task_id = tasks.id ORDER BY date)
+) WHERE id IN (SELECT task_id FROM task_daily_progress);
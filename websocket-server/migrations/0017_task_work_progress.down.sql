@@ -0,0 +1,3 @@
+ALTER TABLE tasks DROP COLUMN progress_notes;
+ALTER TABLE tasks DROP COLUMN time_spent;
+ALTER TABLE tasks DROP COLUMN work_progress;
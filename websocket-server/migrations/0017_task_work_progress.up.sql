@@ -0,0 +1,4 @@
+-- iOS工作任务的进度: 完成百分比(0-100)、累计用时（小时）和进度备注
+ALTER TABLE tasks ADD COLUMN work_progress REAL DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN time_spent REAL DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN progress_notes TEXT DEFAULT '';
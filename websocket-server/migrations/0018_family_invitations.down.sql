@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_family_invitations_user;
+DROP TABLE IF EXISTS family_invitations;
@@ -0,0 +1,11 @@
+-- 家长邀请已有账号加入家庭，被邀请的用户接受后才成为成员
+CREATE TABLE IF NOT EXISTS family_invitations (
+	id TEXT PRIMARY KEY,
+	family_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	role TEXT NOT NULL,
+	invited_by TEXT NOT NULL,
+	created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (family_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_family_invitations_user ON family_invitations (user_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS task_search_state;
+DROP TABLE IF EXISTS task_search;
@@ -0,0 +1,9 @@
+-- 任务全文索引(FTS4，go-sqlite3默认编译)，按task_id关联任务，不依赖tasks的rowid（VACUUM后可能变化）。
+-- 索引内容由程序分词后写入，启动时发现索引缺失或版本不一致才重建
+DROP TABLE IF EXISTS task_search;
+CREATE VIRTUAL TABLE task_search USING fts4(task_id, title, description, notes, notindexed=task_id, tokenize=unicode61);
+CREATE TABLE IF NOT EXISTS task_search_state (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	version INTEGER NOT NULL,
+	rebuilt_at TEXT
+);
@@ -0,0 +1,3 @@
+ALTER TABLE saved_filters DROP COLUMN members_synced_at;
+DROP INDEX IF EXISTS idx_smart_list_members_task;
+DROP TABLE IF EXISTS smart_list_members;
@@ -0,0 +1,9 @@
+-- 智能列表上次计算的成员，任务变化后与之比较，只在任务进入或离开列表时通知
+CREATE TABLE IF NOT EXISTS smart_list_members (
+	list_id TEXT NOT NULL,
+	task_id TEXT NOT NULL,
+	PRIMARY KEY (list_id, task_id)
+);
+CREATE INDEX IF NOT EXISTS idx_smart_list_members_task ON smart_list_members (task_id);
+-- 为空表示成员还没有计算过，启动时补算
+ALTER TABLE saved_filters ADD COLUMN members_synced_at TEXT DEFAULT '';
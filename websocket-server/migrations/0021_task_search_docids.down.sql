@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS task_search;
+CREATE VIRTUAL TABLE task_search USING fts4(task_id, title, description, notes, notindexed=task_id, tokenize=unicode61);
+DROP TABLE IF EXISTS task_search_ids;
+DELETE FROM task_search_state;
@@ -0,0 +1,8 @@
+-- 全文索引的docid改为task_search_ids中为每个任务分配的整数，按docid删除索引行不再扫描整个索引表。
+-- 仍使用FTS4（go-sqlite3默认编译，FTS5需要sqlite_fts5构建标签）；索引由启动时按新版本号重建
+CREATE TABLE IF NOT EXISTS task_search_ids (
+	docid INTEGER PRIMARY KEY AUTOINCREMENT,
+	task_id TEXT NOT NULL UNIQUE
+);
+DROP TABLE IF EXISTS task_search;
+CREATE VIRTUAL TABLE task_search USING fts4(title, description, notes, tokenize=unicode61);
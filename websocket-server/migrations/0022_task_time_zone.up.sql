@@ -0,0 +1,2 @@
+-- 重复任务展开时使用的时区（IANA名称或+08:00形式的偏移），为空时按开始时间自带的时区展开
+ALTER TABLE tasks ADD COLUMN time_zone TEXT DEFAULT '';
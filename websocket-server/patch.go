@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// ErrInvalidPatch 补丁格式错误或无法应用到任务上
+var ErrInvalidPatch = errors.New("无效的补丁")
+
+// 补丁的媒体类型
+const (
+	mediaMergePatch = "application/merge-patch+json" // RFC 7386
+	mediaJSONPatch  = "application/json-patch+json"  // RFC 6902
+)
+
+// JSON Patch的一个操作
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+func invalidPatch(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidPatch, fmt.Sprintf(format, args...))
+}
+
+// 把补丁应用到任务的JSON表示上，返回修改后的任务。
+// ID、创建信息、删除信息和字段版本由服务器维护，补丁中的修改会被忽略；
+// JSON Patch的test操作不满足时返回带当前任务的ConflictError
+func applyTaskPatch(current *Task, patch []byte, jsonPatch bool) (*Task, error) {
+	encoded, err := json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(encoded, &doc); err != nil {
+		return nil, err
+	}
+
+	if jsonPatch {
+		var operations []patchOperation
+		if err := json.Unmarshal(patch, &operations); err != nil {
+			return nil, invalidPatch("%v", err)
+		}
+		for _, operation := range operations {
+			if doc, err = operation.apply(doc); err != nil {
+				if err == errPatchTestFailed {
+					return nil, &ConflictError{Current: current}
+				}
+				return nil, err
+			}
+		}
+	} else {
+		var merge interface{}
+		if err := json.Unmarshal(patch, &merge); err != nil {
+			return nil, invalidPatch("%v", err)
+		}
+		doc = mergePatch(doc, merge)
+	}
+
+	encoded, err = json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	var task Task
+	if err := decoder.Decode(&task); err != nil {
+		return nil, invalidPatch("%v", err)
+	}
+
+	task.ID = current.ID
+	task.CreatedAt = current.CreatedAt
+	task.UpdatedAt = current.UpdatedAt
+	task.CreatedBy = current.CreatedBy
+	task.DeletedAt = current.DeletedAt
+	task.DeletedBy = current.DeletedBy
+	task.FieldRevisions = current.FieldRevisions
+	task.OccurrenceDate = ""
+	return &task, nil
+}
+
+// RFC 7386: null删除字段，对象递归合并，其他值直接替换
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = mergePatch(targetObject[key], value)
+		}
+	}
+	return targetObject
+}
+
+var errPatchTestFailed = errors.New("test操作不满足")
+
+// RFC 6902的单个操作
+func (op patchOperation) apply(doc interface{}) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, invalidPatch("%s操作缺少value", op.Op)
+		}
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, invalidPatch("%v", err)
+		}
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" && strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+			return nil, invalidPatch("不能把%s移动到它的子节点", op.From)
+		}
+		if value, err = pointerGet(doc, from); err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if doc, err = pointerRemove(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			value = deepCopyJSON(value)
+		}
+	case "remove":
+	default:
+		return nil, invalidPatch("未知操作%q", op.Op)
+	}
+
+	switch op.Op {
+	case "add", "move", "copy":
+		return pointerAdd(doc, path, value)
+	case "remove":
+		return pointerRemove(doc, path)
+	case "replace":
+		if _, err := pointerGet(doc, path); err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return value, nil
+		}
+		if doc, err = pointerRemove(doc, path); err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, value)
+	default: // test
+		actual, err := pointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(actual, value) {
+			return nil, errPatchTestFailed
+		}
+		return doc, nil
+	}
+}
+
+// 解析JSON Pointer (RFC 6901)，空字符串表示整个文档
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, invalidPatch("无效的路径%q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func arrayIndex(array []interface{}, token string, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return len(array), nil
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, invalidPatch("无效的数组下标%q", token)
+	}
+	limit := len(array)
+	if allowEnd {
+		limit++
+	}
+	if index >= limit {
+		return 0, invalidPatch("数组下标%d越界", index)
+	}
+	return index, nil
+}
+
+func pointerGet(doc interface{}, path []string) (interface{}, error) {
+	node := doc
+	for _, token := range path {
+		switch container := node.(type) {
+		case map[string]interface{}:
+			child, ok := container[token]
+			if !ok {
+				return nil, invalidPatch("路径/%s不存在", strings.Join(path, "/"))
+			}
+			node = child
+		case []interface{}:
+			index, err := arrayIndex(container, token, false)
+			if err != nil {
+				return nil, err
+			}
+			node = container[index]
+		default:
+			return nil, invalidPatch("路径/%s不存在", strings.Join(path, "/"))
+		}
+	}
+	return node, nil
+}
+
+// 修改path的父节点，返回修改后的文档（数组插入/删除会产生新的切片，需要写回上一层）
+func pointerUpdate(doc interface{}, path []string, update func(parent interface{}, key string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return update(doc, path[0])
+	}
+	child, err := pointerGet(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	if child, err = pointerUpdate(child, path[1:], update); err != nil {
+		return nil, err
+	}
+	switch container := doc.(type) {
+	case map[string]interface{}:
+		container[path[0]] = child
+	case []interface{}:
+		index, _ := arrayIndex(container, path[0], false)
+		container[index] = child
+	}
+	return doc, nil
+}
+
+func pointerAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return pointerUpdate(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		switch container := parent.(type) {
+		case map[string]interface{}:
+			container[key] = value
+			return container, nil
+		case []interface{}:
+			index, err := arrayIndex(container, key, true)
+			if err != nil {
+				return nil, err
+			}
+			container = append(container, nil)
+			copy(container[index+1:], container[index:])
+			container[index] = value
+			return container, nil
+		default:
+			return nil, invalidPatch("路径/%s的父节点不是对象或数组", strings.Join(path, "/"))
+		}
+	})
+}
+
+func pointerRemove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, invalidPatch("不能删除整个任务")
+	}
+	return pointerUpdate(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		switch container := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := container[key]; !ok {
+				return nil, invalidPatch("路径/%s不存在", strings.Join(path, "/"))
+			}
+			delete(container, key)
+			return container, nil
+		case []interface{}:
+			index, err := arrayIndex(container, key, false)
+			if err != nil {
+				return nil, err
+			}
+			return append(container[:index], container[index+1:]...), nil
+		default:
+			return nil, invalidPatch("路径/%s不存在", strings.Join(path, "/"))
+		}
+	})
+}
+
+func deepCopyJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			copied[key] = deepCopyJSON(child)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, child := range v {
+			copied[i] = deepCopyJSON(child)
+		}
+		return copied
+	default:
+		return value
+	}
+}
+
+// 应用补丁并保存。补丁总是作用在最新版本上，没有指定基础版本时，
+// 保存前被其他修改抢先会基于新的版本重新应用补丁。
+// strict时基础版本来自If-Match，不是最新版本直接返回冲突，不做合并
+func patchTask(actorID string, current *Task, patch []byte, jsonPatch bool, baseRevision int64, strict bool) (*Task, error) {
+	for attempt := 0; ; attempt++ {
+		// 孩子眼中待审批的任务就是已申请完成：补丁没有修改is_completed时保持申请，改为false才是撤回
+		base := current
+		if current.ApprovalStatus == ApprovalPending {
+			child, err := isChild(actorID)
+			if err != nil {
+				return nil, err
+			}
+			if child {
+				requested := *current
+				requested.IsCompleted = true
+				base = &requested
+			}
+		}
+		task, err := applyTaskPatch(base, patch, jsonPatch)
+		if err != nil {
+			return nil, err
+		}
+		if baseRevision > 0 {
+			task.Revision = baseRevision
+		}
+		retryable := task.Revision == current.Revision && attempt < 3
+
+		if strict {
+			err = taskService.UpdateTaskIfMatch(actorID, task)
+		} else {
+			err = taskService.UpdateTask(actorID, task)
+		}
+		var conflict *ConflictError
+		if retryable && errors.As(err, &conflict) {
+			current = conflict.Current
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return task, nil
+	}
+}
+
+// 补丁REST处理器: PATCH /api/tasks/{id}。
+// Content-Type为application/json-patch+json时按RFC 6902处理，
+// application/merge-patch+json或application/json时按RFC 7386处理（application/json的数组视为JSON Patch）
+func patchTaskHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var jsonPatch bool
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case mediaJSONPatch:
+		jsonPatch = true
+	case mediaMergePatch:
+	case "", "application/json":
+		jsonPatch = bytes.HasPrefix(bytes.TrimSpace(body), []byte("["))
+	default:
+		w.Header().Set("Accept-Patch", mediaMergePatch+", "+mediaJSONPatch)
+		http.Error(w, "不支持的补丁类型", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	ifMatch, err := parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user := userFromContext(r.Context())
+	current, err := taskService.GetTaskForUser(user.ID, mux.Vars(r)["id"])
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+
+	task, err := patchTask(user.ID, current, body, jsonPatch, ifMatch, ifMatch > 0)
+	if err != nil {
+		var conflict *ConflictError
+		if ifMatch > 0 && errors.As(err, &conflict) {
+			writeConflict(w, http.StatusPreconditionFailed, conflict)
+			return
+		}
+		writeTaskError(w, err)
+		return
+	}
+
+	setETag(w, task)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
+
+// WebSocket消息 patch_task，data为 {id或record_id, patch, base_revision}，
+// patch为对象时按merge patch处理，为数组时按JSON Patch处理
+func handlePatchTask(client *Client, data interface{}) (*Task, error) {
+	taskMap, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidMessage
+	}
+	patch, ok := taskMap["patch"]
+	if !ok {
+		return nil, ErrInvalidMessage
+	}
+	_, jsonPatch := patch.([]interface{})
+	encoded, err := json.Marshal(patch)
+	if err != nil {
+		return nil, ErrInvalidMessage
+	}
+
+	var current *Task
+	if id := getString(taskMap, "id"); id != "" {
+		current, err = taskService.GetTaskForUser(client.userID, id)
+	} else if recordID := getString(taskMap, "record_id"); recordID != "" {
+		current, err = taskService.FindTask(visibleUserIDs(client.userID, client.familyID, client.role), recordID, "", "")
+	} else {
+		return nil, ErrInvalidMessage
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	task, err := patchTask(client.userID, current, encoded, jsonPatch, int64(getInt(taskMap, "base_revision")), false)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("✅ 任务补丁已应用: %s", task.Title)
+	return task, nil
+}
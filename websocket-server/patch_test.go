@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// 把JSON重新编码为键排序后的形式，便于比较
+func canonicalJSON(t *testing.T, value string) string {
+	t.Helper()
+	var doc interface{}
+	if err := json.Unmarshal([]byte(value), &doc); err != nil {
+		t.Fatalf("无效的JSON %s: %v", value, err)
+	}
+	encoded, _ := json.Marshal(doc)
+	return string(encoded)
+}
+
+func TestMergePatch(t *testing.T) {
+	// RFC 7386 附录A中的例子
+	tests := []struct {
+		target, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		var target, patch interface{}
+		json.Unmarshal([]byte(tt.target), &target)
+		json.Unmarshal([]byte(tt.patch), &patch)
+		encoded, _ := json.Marshal(mergePatch(target, patch))
+		if got := string(encoded); got != canonicalJSON(t, tt.want) {
+			t.Errorf("mergePatch(%s, %s) = %s, want %s", tt.target, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestJSONPatchOperations(t *testing.T) {
+	tests := []struct {
+		name       string
+		doc        string
+		operations string
+		want       string
+		wantErr    error
+	}{
+		{"add对象成员", `{"a":1}`, `[{"op":"add","path":"/b","value":2}]`, `{"a":1,"b":2}`, nil},
+		{"add替换已有成员", `{"a":1}`, `[{"op":"add","path":"/a","value":[1]}]`, `{"a":[1]}`, nil},
+		{"add插入数组", `{"a":[1,3]}`, `[{"op":"add","path":"/a/1","value":2}]`, `{"a":[1,2,3]}`, nil},
+		{"add追加到数组末尾", `{"a":[1]}`, `[{"op":"add","path":"/a/-","value":2}]`, `{"a":[1,2]}`, nil},
+		{"add嵌套对象", `{"a":{"b":{}}}`, `[{"op":"add","path":"/a/b/c","value":true}]`, `{"a":{"b":{"c":true}}}`, nil},
+		{"转义的路径", `{}`, `[{"op":"add","path":"/a~1b~0c","value":1}]`, `{"a/b~c":1}`, nil},
+		{"remove成员", `{"a":1,"b":2}`, `[{"op":"remove","path":"/a"}]`, `{"b":2}`, nil},
+		{"remove数组元素", `{"a":[1,2,3]}`, `[{"op":"remove","path":"/a/0"}]`, `{"a":[2,3]}`, nil},
+		{"replace", `{"a":1}`, `[{"op":"replace","path":"/a","value":"x"}]`, `{"a":"x"}`, nil},
+		{"move", `{"a":{"b":1},"c":{}}`, `[{"op":"move","from":"/a/b","path":"/c/d"}]`, `{"a":{},"c":{"d":1}}`, nil},
+		{"move数组元素", `{"a":[1,2,3]}`, `[{"op":"move","from":"/a/0","path":"/a/-"}]`, `{"a":[2,3,1]}`, nil},
+		{"copy是深拷贝", `{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`,
+			`{"a":{"b":1},"c":{"b":2}}`, nil},
+		{"test通过", `{"a":[1,{"b":"c"}]}`, `[{"op":"test","path":"/a","value":[1,{"b":"c"}]}]`, `{"a":[1,{"b":"c"}]}`, nil},
+		{"按顺序执行", `{"a":1}`, `[{"op":"add","path":"/b","value":1},{"op":"remove","path":"/a"},{"op":"replace","path":"/b","value":2}]`,
+			`{"b":2}`, nil},
+		{"test失败", `{"a":1}`, `[{"op":"test","path":"/a","value":2}]`, "", errPatchTestFailed},
+		{"remove不存在的成员", `{"a":1}`, `[{"op":"remove","path":"/b"}]`, "", ErrInvalidPatch},
+		{"replace不存在的成员", `{"a":1}`, `[{"op":"replace","path":"/b","value":1}]`, "", ErrInvalidPatch},
+		{"数组下标越界", `{"a":[1]}`, `[{"op":"add","path":"/a/3","value":1}]`, "", ErrInvalidPatch},
+		{"数组下标不能有前导0", `{"a":[1,2]}`, `[{"op":"remove","path":"/a/01"}]`, "", ErrInvalidPatch},
+		{"父节点不存在", `{}`, `[{"op":"add","path":"/a/b","value":1}]`, "", ErrInvalidPatch},
+		{"路径必须以/开头", `{}`, `[{"op":"add","path":"a","value":1}]`, "", ErrInvalidPatch},
+		{"不能移动到子节点", `{"a":{}}`, `[{"op":"move","from":"/a","path":"/a/b"}]`, "", ErrInvalidPatch},
+		{"缺少value", `{}`, `[{"op":"add","path":"/a"}]`, "", ErrInvalidPatch},
+		{"未知操作", `{}`, `[{"op":"merge","path":"/a","value":1}]`, "", ErrInvalidPatch},
+		{"不能删除整个文档", `{}`, `[{"op":"remove","path":""}]`, "", ErrInvalidPatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc interface{}
+			var operations []patchOperation
+			if err := json.Unmarshal([]byte(tt.doc), &doc); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.operations), &operations); err != nil {
+				t.Fatal(err)
+			}
+			var err error
+			for _, operation := range operations {
+				if doc, err = operation.apply(doc); err != nil {
+					break
+				}
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("apply() = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("apply() = %v", err)
+			}
+			encoded, _ := json.Marshal(doc)
+			if got := string(encoded); got != canonicalJSON(t, tt.want) {
+				t.Errorf("apply() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyTaskPatch(t *testing.T) {
+	current := &Task{ID: "task_1", UserID: "u1", Title: "练琴", Description: "30分钟", Priority: 1,
+		CreatedBy: "u1", Revision: 3, FieldRevisions: map[string]int64{allFields: 1}}
+
+	tests := []struct {
+		name      string
+		patch     string
+		jsonPatch bool
+		wantErr   error
+		check     func(task *Task) bool
+	}{
+		{name: "merge patch修改字段", patch: `{"title":"练琴1小时","priority":2}`,
+			check: func(task *Task) bool {
+				return task.Title == "练琴1小时" && task.Priority == 2 && task.Description == "30分钟"
+			}},
+		{name: "merge patch中的null清空字段", patch: `{"description":null}`,
+			check: func(task *Task) bool { return task.Description == "" && task.Title == "练琴" }},
+		{name: "服务器维护的字段不能修改", patch: `{"id":"task_2","created_by":"u2","field_revisions":{"title":9}}`,
+			check: func(task *Task) bool {
+				return task.ID == "task_1" && task.CreatedBy == "u1" && task.FieldRevisions[allFields] == 1 && len(task.FieldRevisions) == 1
+			}},
+		{name: "补丁中的revision作为基础版本", patch: `{"revision":2}`,
+			check: func(task *Task) bool { return task.Revision == 2 }},
+		{name: "JSON Patch", patch: `[{"op":"test","path":"/title","value":"练琴"},{"op":"replace","path":"/title","value":"钢琴"}]`, jsonPatch: true,
+			check: func(task *Task) bool { return task.Title == "钢琴" }},
+		{name: "JSON Patch的test不满足时冲突", patch: `[{"op":"test","path":"/title","value":"别的"}]`, jsonPatch: true,
+			wantErr: ErrConflict},
+		{name: "未知字段", patch: `{"colour":"red"}`, wantErr: ErrInvalidPatch},
+		{name: "字段类型错误", patch: `{"priority":"high"}`, wantErr: ErrInvalidPatch},
+		{name: "补丁不是JSON", patch: `{`, wantErr: ErrInvalidPatch},
+		{name: "JSON Patch必须是数组", patch: `{"op":"add"}`, jsonPatch: true, wantErr: ErrInvalidPatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task, err := applyTaskPatch(current, []byte(tt.patch), tt.jsonPatch)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("applyTaskPatch() = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyTaskPatch() = %v", err)
+			}
+			if !tt.check(task) {
+				t.Errorf("applyTaskPatch() = %+v", task)
+			}
+		})
+	}
+	if current.Title != "练琴" || current.Description != "30分钟" {
+		t.Error("applyTaskPatch不应修改当前任务")
+	}
+}
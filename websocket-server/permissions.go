@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+)
+
+// ErrForbidden 当前用户的角色不允许执行该操作
+var ErrForbidden = errors.New("没有权限执行该操作")
+
+// 能否管理（创建/分配/修改/删除）ownerID名下的任务：
+// 家长可以管理家庭成员的任务；不是孩子的用户可以管理自己的任务
+func canManageTasksOf(actorID, ownerID string) (bool, error) {
+	if actorID == ownerID {
+		child, err := isChild(actorID)
+		if err != nil {
+			return false, err
+		}
+		return !child, nil
+	}
+	return isParentOf(actorID, ownerID)
+}
+
+// 能否查看任务：任务所有者本人，或所有者家庭中的家长
+func canViewTask(actorID string, task *Task) (bool, error) {
+	if actorID == task.UserID {
+		return true, nil
+	}
+	return isParentOf(actorID, task.UserID)
+}
+
+// 查看任务，无权查看时按不存在处理，避免泄露其他家庭的任务
+func authorizeTaskView(actorID string, task *Task) error {
+	ok, err := canViewTask(actorID, task)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrTaskNotFound
+	}
+	return nil
+}
+
+func authorizeTaskCreate(actorID, ownerID string) error {
+	ok, err := canManageTasksOf(actorID, ownerID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// 修改任务：能管理的用户可以修改全部字段（重新分配时还需要能管理新的所有者）；
+// 孩子只能修改自己任务的完成状态和每日进度
+func authorizeTaskUpdate(actorID string, current, updated *Task) error {
+	if err := authorizeTaskView(actorID, current); err != nil {
+		return err
+	}
+
+	ok, err := canManageTasksOf(actorID, current.UserID)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if updated.UserID != current.UserID {
+			return authorizeTaskCreate(actorID, updated.UserID)
+		}
+		return nil
+	}
+
+	if actorID == current.UserID && onlyChildEditableFieldsChanged(current, updated) {
+		return nil
+	}
+	return ErrForbidden
+}
+
+func authorizeTaskDelete(actorID string, task *Task) error {
+	if err := authorizeTaskView(actorID, task); err != nil {
+		return err
+	}
+	return authorizeTaskCreate(actorID, task.UserID)
+}
+
+// 孩子只能修改is_completed和daily_progress，审批、版本号等字段由服务器维护，客户端提交的值不生效；
+// 其余字段（包括工作进度、提醒和设备同步信息）只能由家长修改
+func onlyChildEditableFieldsChanged(current, updated *Task) bool {
+	return current.UserID == updated.UserID &&
+		current.Title == updated.Title &&
+		current.Description == updated.Description &&
+		current.StartDate == updated.StartDate &&
+		current.DueDate == updated.DueDate &&
+		current.Category == updated.Category &&
+		current.Priority == updated.Priority &&
+		current.Points == updated.Points &&
+		current.RRule == updated.RRule &&
+		current.ExDates == updated.ExDates &&
+		current.TimeZone == updated.TimeZone &&
+		current.ReminderOffsets == updated.ReminderOffsets &&
+		current.WorkProgress == updated.WorkProgress &&
+		current.TimeSpent == updated.TimeSpent &&
+		current.ProgressNotes == updated.ProgressNotes &&
+		current.DeviceID == updated.DeviceID &&
+		current.RecordID == updated.RecordID
+}
+
+// 本人或家长才能查看任务列表
+func authorizeTaskList(actorID, ownerID string) error {
+	if actorID == ownerID {
+		return nil
+	}
+	ok, err := isParentOf(actorID, ownerID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrForbidden
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import "testing"
+
+// 家庭f1: 家长parent、parent2，孩子child；家庭f2: 家长other；solo不属于任何家庭
+func setupPermissionFamilies(t *testing.T) {
+	openTestDB(t)
+	addTestMember(t, "f1", "parent", RoleParent)
+	addTestMember(t, "f1", "parent2", RoleParent)
+	addTestMember(t, "f1", "child", RoleChild)
+	addTestMember(t, "f2", "other", RoleParent)
+}
+
+func TestAuthorizeTaskUpdate(t *testing.T) {
+	setupPermissionFamilies(t)
+
+	childTask := Task{ID: "t1", UserID: "child", Title: "练琴", Points: 5}
+	parentTask := Task{ID: "t2", UserID: "parent", Title: "买菜"}
+	soloTask := Task{ID: "t3", UserID: "solo", Title: "跑步"}
+
+	tests := []struct {
+		name    string
+		actorID string
+		current Task
+		change  func(task *Task)
+		want    error
+	}{
+		{"家长修改孩子的任务", "parent", childTask, func(task *Task) { task.Title = "练琴30分钟" }, nil},
+		{"另一位家长修改孩子的任务", "parent2", childTask, func(task *Task) { task.Points = 10 }, nil},
+		{"家长修改自己的任务", "parent", parentTask, func(task *Task) { task.Title = "买水果" }, nil},
+		{"家长把任务分配给同家庭的家长", "parent", childTask, func(task *Task) { task.UserID = "parent2" }, nil},
+		{"家长把任务分配给家庭外的用户", "parent", childTask, func(task *Task) { task.UserID = "solo" }, ErrForbidden},
+		{"其他家庭的家长看不到任务", "other", childTask, func(task *Task) { task.Title = "x" }, ErrTaskNotFound},
+		{"孩子完成自己的任务", "child", childTask, func(task *Task) { task.IsCompleted = true }, nil},
+		{"孩子更新每日进度", "child", childTask, func(task *Task) { task.DailyProgress = `{"2026-10-01":{"progress":50}}` }, nil},
+		{"孩子修改工作进度", "child", childTask, func(task *Task) { task.WorkProgress = 50 }, ErrForbidden},
+		{"孩子修改用时", "child", childTask, func(task *Task) { task.TimeSpent = 1.5 }, ErrForbidden},
+		{"孩子修改进度备注", "child", childTask, func(task *Task) { task.ProgressNotes = "做了一半" }, ErrForbidden},
+		{"孩子修改提醒", "child", childTask, func(task *Task) { task.ReminderOffsets = "10" }, ErrForbidden},
+		{"孩子修改设备ID", "child", childTask, func(task *Task) { task.DeviceID = "kid-ipad" }, ErrForbidden},
+		{"孩子修改记录ID", "child", childTask, func(task *Task) { task.RecordID = "record-2" }, ErrForbidden},
+		{"孩子提交的审批字段不算修改", "child", childTask, func(task *Task) { task.ApprovalStatus = ApprovalApproved }, nil},
+		{"孩子修改标题", "child", childTask, func(task *Task) { task.Title = "不练了" }, ErrForbidden},
+		{"孩子修改积分", "child", childTask, func(task *Task) { task.Points = 100 }, ErrForbidden},
+		{"孩子修改重复规则", "child", childTask, func(task *Task) { task.RRule = "FREQ=DAILY" }, ErrForbidden},
+		{"孩子把任务转给家长", "child", childTask, func(task *Task) { task.UserID = "parent" }, ErrForbidden},
+		{"孩子看不到家长的任务", "child", parentTask, func(task *Task) { task.IsCompleted = true }, ErrTaskNotFound},
+		{"没有家庭的用户修改自己的任务", "solo", soloTask, func(task *Task) { task.Title = "跑步5公里" }, nil},
+		{"家长看不到家庭外用户的任务", "parent", soloTask, func(task *Task) { task.Title = "x" }, ErrTaskNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := tt.current
+			updated := tt.current
+			tt.change(&updated)
+			if err := authorizeTaskUpdate(tt.actorID, &current, &updated); err != tt.want {
+				t.Errorf("authorizeTaskUpdate() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizeTaskCreateAndDelete(t *testing.T) {
+	setupPermissionFamilies(t)
+
+	tests := []struct {
+		actorID, ownerID string
+		want             error
+	}{
+		{"parent", "child", nil},
+		{"parent", "parent2", nil},
+		{"parent", "parent", nil},
+		{"solo", "solo", nil},
+		{"child", "child", ErrForbidden},
+		{"child", "parent", ErrForbidden},
+		{"other", "child", ErrForbidden},
+		{"parent", "solo", ErrForbidden},
+	}
+	for _, tt := range tests {
+		if err := authorizeTaskCreate(tt.actorID, tt.ownerID); err != tt.want {
+			t.Errorf("authorizeTaskCreate(%s, %s) = %v, want %v", tt.actorID, tt.ownerID, err, tt.want)
+		}
+	}
+
+	deletes := []struct {
+		actorID, ownerID string
+		want             error
+	}{
+		{"parent", "child", nil},
+		{"solo", "solo", nil},
+		{"child", "child", ErrForbidden},
+		{"other", "child", ErrTaskNotFound},
+		{"child", "parent", ErrTaskNotFound},
+	}
+	for _, tt := range deletes {
+		task := &Task{ID: "t1", UserID: tt.ownerID}
+		if err := authorizeTaskDelete(tt.actorID, task); err != tt.want {
+			t.Errorf("authorizeTaskDelete(%s, %s) = %v, want %v", tt.actorID, tt.ownerID, err, tt.want)
+		}
+	}
+}
+
+func TestAuthorizeTaskList(t *testing.T) {
+	setupPermissionFamilies(t)
+
+	tests := []struct {
+		actorID, ownerID string
+		want             error
+	}{
+		{"child", "child", nil},
+		{"parent", "child", nil},
+		{"parent2", "parent", nil},
+		{"child", "parent", ErrForbidden},
+		{"other", "child", ErrForbidden},
+		{"solo", "parent", ErrForbidden},
+	}
+	for _, tt := range tests {
+		if err := authorizeTaskList(tt.actorID, tt.ownerID); err != tt.want {
+			t.Errorf("authorizeTaskList(%s, %s) = %v, want %v", tt.actorID, tt.ownerID, err, tt.want)
+		}
+	}
+}
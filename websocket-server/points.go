@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 积分流水类型
+const (
+	PointsTaskCompleted = "task_completed" // 任务完成/审批通过入账
+	PointsTaskReverted  = "task_reverted"  // 任务取消完成，冲销之前的入账
+	PointsRedeemed      = "redeemed"       // 兑换奖励
+)
+
+// ErrInsufficientPoints 积分余额不足
+var ErrInsufficientPoints = errors.New("积分余额不足")
+
+// PointsEntry 一条积分流水
+type PointsEntry struct {
+	ID        int64  `json:"id"`
+	UserID    string `json:"user_id"`
+	Delta     int    `json:"delta"`
+	Kind      string `json:"kind"`
+	Reason    string `json:"reason"`
+	TaskID    string `json:"task_id"`
+	CreatedBy string `json:"created_by"`
+	CreatedAt string `json:"created_at"`
+}
+
+func pointsBalance(userID string) (int, error) {
+	var balance int
+	err := db.QueryRow("SELECT COALESCE(SUM(delta), 0) FROM points_ledger WHERE user_id = ?", userID).Scan(&balance)
+	return balance, err
+}
+
+func pointsHistory(userID string, limit int) ([]PointsEntry, error) {
+	rows, err := db.Query(`SELECT id, user_id, delta, kind, COALESCE(reason, ''), COALESCE(task_id, ''),
+		COALESCE(created_by, ''), COALESCE(created_at, '')
+		FROM points_ledger WHERE user_id = ? ORDER BY id DESC LIMIT ?`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []PointsEntry{}
+	for rows.Next() {
+		var e PointsEntry
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Delta, &e.Kind, &e.Reason, &e.TaskID, &e.CreatedBy, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func addPointsEntry(entry *PointsEntry) error {
+	entry.CreatedAt = time.Now().UTC().Format(timeLayout)
+	result, err := db.Exec(`INSERT INTO points_ledger (user_id, delta, kind, reason, task_id, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		entry.UserID, entry.Delta, entry.Kind, entry.Reason, entry.TaskID, entry.CreatedBy, entry.CreatedAt)
+	if err != nil {
+		return err
+	}
+	entry.ID, err = result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	broadcastPointsChanged(entry)
+	return nil
+}
+
+// 任务完成状态变化后结算积分：完成时入账一次，取消完成时冲销，
+// 按任务的流水净额判断，重复提交不会重复入账
+func settleTaskPoints(actorID string, task *Task, wasCompleted bool) {
+	if task.IsCompleted == wasCompleted {
+		return
+	}
+
+	taskID := pointsLedgerKey(task)
+	var net int
+	err := db.QueryRow("SELECT COALESCE(SUM(delta), 0) FROM points_ledger WHERE task_id = ? AND user_id = ?",
+		taskID, task.UserID).Scan(&net)
+	if err != nil {
+		log.Printf("❌ 查询任务积分失败: %v", err)
+		return
+	}
+
+	entry := &PointsEntry{UserID: task.UserID, TaskID: taskID, Reason: task.Title, CreatedBy: actorID}
+	switch {
+	case task.IsCompleted && net == 0 && task.Points > 0:
+		entry.Delta = task.Points
+		entry.Kind = PointsTaskCompleted
+	case !task.IsCompleted && net > 0:
+		entry.Delta = -net
+		entry.Kind = PointsTaskReverted
+	default:
+		return
+	}
+
+	if err := addPointsEntry(entry); err != nil {
+		log.Printf("❌ 记录积分流水失败: %v", err)
+		return
+	}
+	log.Printf("⭐ 积分变动: user=%s, delta=%d, task=%s", entry.UserID, entry.Delta, task.Title)
+}
+
+// 积分流水中的任务标识，重复任务的每次实例单独结算: <id>@<YYYY-MM-DD>
+func pointsLedgerKey(task *Task) string {
+	if task.OccurrenceDate != "" {
+		return getTaskIDString(task) + "@" + task.OccurrenceDate
+	}
+	return getTaskIDString(task)
+}
+
+// 兑换奖励，余额不足时拒绝。检查余额和扣减在同一事务中完成
+func redeemPoints(actorID, userID string, amount int, reason string) (*PointsEntry, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var balance int
+	if err := tx.QueryRow("SELECT COALESCE(SUM(delta), 0) FROM points_ledger WHERE user_id = ?", userID).Scan(&balance); err != nil {
+		return nil, err
+	}
+	if balance < amount {
+		return nil, ErrInsufficientPoints
+	}
+
+	entry := &PointsEntry{
+		UserID:    userID,
+		Delta:     -amount,
+		Kind:      PointsRedeemed,
+		Reason:    reason,
+		CreatedBy: actorID,
+		CreatedAt: time.Now().UTC().Format(timeLayout),
+	}
+	result, err := tx.Exec(`INSERT INTO points_ledger (user_id, delta, kind, reason, task_id, created_by, created_at)
+		VALUES (?, ?, ?, ?, '', ?, ?)`,
+		entry.UserID, entry.Delta, entry.Kind, entry.Reason, entry.CreatedBy, entry.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if entry.ID, err = result.LastInsertId(); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	broadcastPointsChanged(entry)
+	return entry, nil
+}
+
+// 通知积分所属用户及其家庭中的家长
+func broadcastPointsChanged(entry *PointsEntry) {
+	balance, err := pointsBalance(entry.UserID)
+	if err != nil {
+		log.Printf("❌ 查询积分余额失败: %v", err)
+		return
+	}
+	sendBroadcast(entry.UserID, WSMessage{
+		Type: "points_changed",
+		Data: map[string]interface{}{
+			"user_id": entry.UserID,
+			"balance": balance,
+			"entry":   entry,
+		},
+	}, false)
+}
+
+// 解析积分接口的目标用户：默认自己，家长可以指定孩子的user_id
+func pointsTargetUser(r *http.Request) (string, error) {
+	actorID := userFromContext(r.Context()).ID
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" || userID == actorID {
+		return actorID, nil
+	}
+	ok, err := isParentOf(actorID, userID)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrForbidden
+	}
+	return userID, nil
+}
+
+// 积分REST处理器
+func pointsBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := pointsTargetUser(r)
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+
+	balance, err := pointsBalance(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"user_id": userID, "balance": balance})
+}
+
+func pointsHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := pointsTargetUser(r)
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+
+	limit := 50
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 500 {
+		limit = l
+	}
+
+	entries, err := pointsHistory(userID, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+func redeemPointsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := pointsTargetUser(r)
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+
+	var req struct {
+		Amount int    `json:"amount"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Amount <= 0 {
+		http.Error(w, "兑换积分必须大于0", http.StatusBadRequest)
+		return
+	}
+
+	entry, err := redeemPoints(userFromContext(r.Context()).ID, userID, req.Amount, req.Reason)
+	if err != nil {
+		if err == ErrInsufficientPoints {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("🎁 兑换奖励: user=%s, amount=%d, reason=%s", userID, req.Amount, req.Reason)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
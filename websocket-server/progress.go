@@ -0,0 +1,404 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	// ErrInvalidProgress 进度的日期、百分比或用时不合法
+	ErrInvalidProgress = errors.New("无效的进度")
+	// ErrNoProgress 该日期没有进度记录
+	ErrNoProgress = errors.New("该日期没有进度记录")
+)
+
+// DailyProgress 任务某一天的进度，保存在task_daily_progress表中
+type DailyProgress struct {
+	TaskID       string `json:"task_id"`
+	Date         string `json:"date"`
+	Progress     int    `json:"progress"`
+	MinutesSpent int    `json:"minutes_spent"`
+	Note         string `json:"note"`
+	UpdatedBy    string `json:"updated_by"`
+	UpdatedAt    string `json:"updated_at"`
+}
+
+// 旧字段tasks.daily_progress中每一天的内容，字段顺序与迁移中的json_object一致
+type dailyProgressValue struct {
+	Progress     int    `json:"progress"`
+	MinutesSpent int    `json:"minutes_spent"`
+	Note         string `json:"note"`
+}
+
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func invalidProgress(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidProgress, fmt.Sprintf(format, args...))
+}
+
+func validateDailyProgress(p *DailyProgress) error {
+	if _, err := time.Parse(occurrenceDateLayout, p.Date); err != nil {
+		return invalidProgress("日期格式应为YYYY-MM-DD: %s", p.Date)
+	}
+	if p.Progress < 0 || p.Progress > 100 {
+		return invalidProgress("进度应在0到100之间: %d", p.Progress)
+	}
+	if p.MinutesSpent < 0 {
+		return invalidProgress("用时不能为负数: %d", p.MinutesSpent)
+	}
+	return nil
+}
+
+// 任务整体的工作进度: 百分比在0到100之间，累计用时（小时）不能为负数
+func validateWorkProgress(task *Task) error {
+	if math.IsNaN(task.WorkProgress) || task.WorkProgress < 0 || task.WorkProgress > 100 {
+		return invalidProgress("工作进度应在0到100之间: %v", task.WorkProgress)
+	}
+	if math.IsNaN(task.TimeSpent) || task.TimeSpent < 0 {
+		return invalidProgress("用时不能为负数: %v", task.TimeSpent)
+	}
+	return nil
+}
+
+const dailyProgressColumns = `task_id, date, COALESCE(progress, 0), COALESCE(minutes_spent, 0), COALESCE(note, ''),
+	COALESCE(updated_by, ''), COALESCE(updated_at, '')`
+
+func scanDailyProgress(row rowScanner) (*DailyProgress, error) {
+	var p DailyProgress
+	if err := row.Scan(&p.TaskID, &p.Date, &p.Progress, &p.MinutesSpent, &p.Note, &p.UpdatedBy, &p.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func loadDailyProgress(q querier, taskID string) ([]DailyProgress, error) {
+	rows, err := q.Query("SELECT "+dailyProgressColumns+" FROM task_daily_progress WHERE task_id = ? ORDER BY date", taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []DailyProgress{}
+	for rows.Next() {
+		p, err := scanDailyProgress(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *p)
+	}
+	return entries, rows.Err()
+}
+
+func getDailyProgress(q querier, taskID, date string) (*DailyProgress, error) {
+	p, err := scanDailyProgress(q.QueryRow("SELECT "+dailyProgressColumns+" FROM task_daily_progress WHERE task_id = ? AND date = ?", taskID, date))
+	if err == sql.ErrNoRows {
+		return nil, ErrNoProgress
+	}
+	return p, err
+}
+
+// 写入一天的进度，内容没有变化时保留原来的修改人和时间
+func upsertDailyProgress(tx *sql.Tx, p *DailyProgress) error {
+	_, err := tx.Exec(`INSERT INTO task_daily_progress (task_id, date, progress, minutes_spent, note, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (task_id, date) DO UPDATE SET
+		progress = excluded.progress, minutes_spent = excluded.minutes_spent, note = excluded.note,
+		updated_by = excluded.updated_by, updated_at = excluded.updated_at
+		WHERE progress != excluded.progress OR minutes_spent != excluded.minutes_spent OR note != excluded.note`,
+		p.TaskID, p.Date, p.Progress, p.MinutesSpent, p.Note, p.UpdatedBy, p.UpdatedAt)
+	return err
+}
+
+// 由进度记录生成旧字段daily_progress: {"2024-01-02": {"progress": 50, "minutes_spent": 30, "note": "..."}}
+func dailyProgressBlob(entries []DailyProgress) string {
+	values := make(map[string]dailyProgressValue, len(entries))
+	for _, p := range entries {
+		values[p.Date] = dailyProgressValue{Progress: p.Progress, MinutesSpent: p.MinutesSpent, Note: p.Note}
+	}
+	data, _ := json.Marshal(values)
+	return string(data)
+}
+
+// 解析旧客户端提交的daily_progress，规则与迁移0016相同:
+// 键为日期，值可以是对象、百分比数字、完成标记或备注文字，其他内容忽略；不是JSON对象时返回nil
+func parseDailyProgressBlob(taskID, blob string) []DailyProgress {
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(blob), &values); err != nil || values == nil {
+		return nil
+	}
+
+	var entries []DailyProgress
+	for key, value := range values {
+		if len(key) < 10 {
+			continue
+		}
+		date := key[:10]
+		if _, err := time.Parse(occurrenceDateLayout, date); err != nil {
+			continue
+		}
+		p := DailyProgress{TaskID: taskID, Date: date}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			p.Progress = int(math.Round(firstNumber(v, "progress", "percent")))
+			p.MinutesSpent = int(firstNumber(v, "minutes_spent", "minutes"))
+			p.Note = firstString(v, "note", "notes")
+		case float64:
+			p.Progress = int(math.Round(v))
+		case bool:
+			if v {
+				p.Progress = 100
+			}
+		case string:
+			p.Note = v
+		}
+		if p.Progress < 0 {
+			p.Progress = 0
+		} else if p.Progress > 100 {
+			p.Progress = 100
+		}
+		if p.MinutesSpent < 0 {
+			p.MinutesSpent = 0
+		}
+		entries = append(entries, p)
+	}
+	return entries
+}
+
+func firstNumber(m map[string]interface{}, keys ...string) float64 {
+	for _, key := range keys {
+		if v, ok := m[key].(float64); ok {
+			return v
+		}
+	}
+	return 0
+}
+
+func firstString(m map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if v, ok := m[key].(string); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// 旧客户端整体提交的daily_progress只做合并：其中的日期逐条写入进度表，提交中没有的日期保留，
+// 这样先于/progress/{date}写入生成的旧内容不会删掉新的记录。空对象（旧客户端的默认值）
+// 或不是JSON对象时不修改进度表。返回由全部进度记录重新生成的daily_progress
+func importDailyProgress(tx *sql.Tx, taskID, blob, updatedAt string) (string, error) {
+	if blob != "{}" {
+		for _, p := range parseDailyProgressBlob(taskID, blob) {
+			p.UpdatedAt = updatedAt
+			if err := upsertDailyProgress(tx, &p); err != nil {
+				return "", err
+			}
+		}
+	}
+	entries, err := loadDailyProgress(tx, taskID)
+	if err != nil {
+		return "", err
+	}
+	return dailyProgressBlob(entries), nil
+}
+
+// ListDailyProgress 任务的全部进度记录，按日期排序
+func (s *SQLiteTaskStore) ListDailyProgress(taskID string) ([]DailyProgress, error) {
+	return loadDailyProgress(s.db, taskID)
+}
+
+// SaveDailyProgress 保存一天的进度，同时更新任务的daily_progress和版本号，返回更新后的任务
+func (s *SQLiteTaskStore) SaveDailyProgress(p *DailyProgress) (*Task, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := upsertDailyProgress(tx, p); err != nil {
+		return nil, err
+	}
+	entries, err := loadDailyProgress(tx, p.TaskID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 只修改daily_progress一个字段，不需要基础版本，不会与其他修改冲突
+	result, err := tx.Exec(`UPDATE tasks SET daily_progress = ?, updated_at = ?, revision = COALESCE(revision, 1) + 1,
+		field_revisions = json_set(COALESCE(field_revisions, '{}'), '$.daily_progress', COALESCE(revision, 1) + 1)
+		WHERE id = ? AND `+notDeleted, dailyProgressBlob(entries), p.UpdatedAt, p.TaskID)
+	if err != nil {
+		log.Printf("❌ 保存每日进度失败: %v", err)
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrTaskNotFound
+	}
+
+	task, err := scanTask(tx.QueryRow("SELECT "+taskColumns+" FROM tasks WHERE id = ?", p.TaskID))
+	if err != nil {
+		return nil, err
+	}
+	if err := saveTaskHistory(tx, task); err != nil {
+		return nil, err
+	}
+	if err := indexTask(tx, task); err != nil {
+		return nil, err
+	}
+	if saved, err := getDailyProgress(tx, p.TaskID, p.Date); err == nil {
+		*p = *saved
+	}
+	return task, tx.Commit()
+}
+
+// 修改每日进度的请求，未提交的字段保持原值
+type progressRequest struct {
+	Progress     *int    `json:"progress"`
+	MinutesSpent *int    `json:"minutes_spent"`
+	Note         *string `json:"note"`
+}
+
+// ListDailyProgress 能查看任务的用户可以查看它的每日进度
+func (s *TaskService) ListDailyProgress(actorID, taskID string) ([]DailyProgress, error) {
+	if _, err := s.GetTaskForUser(actorID, taskID); err != nil {
+		return nil, err
+	}
+	return s.store.ListDailyProgress(taskID)
+}
+
+func (s *TaskService) GetDailyProgress(actorID, taskID, date string) (*DailyProgress, error) {
+	if _, err := s.GetTaskForUser(actorID, taskID); err != nil {
+		return nil, err
+	}
+	return getDailyProgress(db, taskID, date)
+}
+
+// SetDailyProgress 记录任务某一天的进度。本人（包括孩子）或能管理该任务的家长可以修改
+func (s *TaskService) SetDailyProgress(actorID, taskID, date string, req progressRequest) (*DailyProgress, *Task, error) {
+	task, err := s.GetTaskForUser(actorID, taskID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if actorID != task.UserID {
+		if err := authorizeTaskCreate(actorID, task.UserID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	p, err := getDailyProgress(db, taskID, date)
+	if err == ErrNoProgress {
+		p = &DailyProgress{TaskID: taskID, Date: date}
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if req.Progress != nil {
+		p.Progress = *req.Progress
+	}
+	if req.MinutesSpent != nil {
+		p.MinutesSpent = *req.MinutesSpent
+	}
+	if req.Note != nil {
+		p.Note = *req.Note
+	}
+	if err := validateDailyProgress(p); err != nil {
+		return nil, nil, err
+	}
+	p.UpdatedBy = actorID
+	p.UpdatedAt = time.Now().UTC().Format(timeLayout)
+
+	if task, err = s.store.SaveDailyProgress(p); err != nil {
+		return nil, nil, err
+	}
+	log.Printf("📈 每日进度更新: %s @ %s, progress=%d%%, minutes=%d", task.Title, date, p.Progress, p.MinutesSpent)
+
+	// 旧客户端通过task_updated拿到新的daily_progress，新客户端只需处理progress_updated
+	broadcastTaskChange("task_updated", task)
+	sendBroadcast(task.UserID, WSMessage{Type: "progress_updated", Data: p}, false)
+	return p, task, nil
+}
+
+func writeProgressError(w http.ResponseWriter, err error) {
+	if err == ErrNoProgress {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeTaskError(w, err)
+}
+
+// 每日进度REST处理器: GET /api/tasks/{id}/progress
+func listProgressHandler(w http.ResponseWriter, r *http.Request) {
+	entries, err := taskService.ListDailyProgress(userFromContext(r.Context()).ID, mux.Vars(r)["id"])
+	if err != nil {
+		writeProgressError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// GET /api/tasks/{id}/progress/{date}
+func getProgressHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	p, err := taskService.GetDailyProgress(userFromContext(r.Context()).ID, vars["id"], vars["date"])
+	if err != nil {
+		writeProgressError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// PUT /api/tasks/{id}/progress/{date}，body为 {progress, minutes_spent, note}
+func updateProgressHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var req progressRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p, task, err := taskService.SetDailyProgress(userFromContext(r.Context()).ID, vars["id"], vars["date"], req)
+	if err != nil {
+		writeProgressError(w, err)
+		return
+	}
+	setETag(w, task)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// WebSocket消息 update_progress，data为 {id, date, progress, minutes_spent, note}
+func handleUpdateProgress(client *Client, data interface{}) (*Task, error) {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidMessage
+	}
+
+	var req progressRequest
+	if _, ok := m["progress"]; ok {
+		v := getInt(m, "progress")
+		req.Progress = &v
+	}
+	if _, ok := m["minutes_spent"]; ok {
+		v := getInt(m, "minutes_spent")
+		req.MinutesSpent = &v
+	}
+	if v, ok := m["note"].(string); ok {
+		req.Note = &v
+	}
+
+	_, task, err := taskService.SetDailyProgress(client.userID, getString(m, "id"), getString(m, "date"), req)
+	return task, err
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStaleDailyProgressBlobKeepsNewerEntries(t *testing.T) {
+	tests := []struct {
+		name  string
+		blob  string
+		dates []string
+	}{
+		{"/progress写入之前的旧内容", `{"2024-01-01": 30}`, []string{"2024-01-01", "2024-01-02"}},
+		{"旧客户端的默认值", "{}", []string{"2024-01-01", "2024-01-02"}},
+		{"旧内容里修改已有日期", `{"2024-01-01": 80}`, []string{"2024-01-01", "2024-01-02"}},
+		{"旧内容里新增日期", `{"2024-01-03": true}`, []string{"2024-01-01", "2024-01-02", "2024-01-03"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			stale := createTestTask(t, "kid", Task{UserID: "kid", Title: "练琴", DailyProgress: `{"2024-01-01": 30}`})
+
+			progress := 60
+			if _, _, err := taskService.SetDailyProgress("kid", getTaskIDString(stale), "2024-01-02", progressRequest{Progress: &progress}); err != nil {
+				t.Fatal(err)
+			}
+
+			// 没有带版本号的旧客户端按自己手里的daily_progress整体提交
+			stale.Revision = 0
+			stale.DailyProgress = tt.blob
+			if err := taskService.UpdateTask("kid", stale); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := taskService.ListDailyProgress("kid", getTaskIDString(stale))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var dates []string
+			for _, p := range entries {
+				dates = append(dates, p.Date)
+				if p.Date == "2024-01-02" && p.Progress != 60 {
+					t.Errorf("2024-01-02的进度 = %d, 期望 60", p.Progress)
+				}
+			}
+			if strings.Join(dates, ",") != strings.Join(tt.dates, ",") {
+				t.Errorf("进度日期 = %v, 期望 %v", dates, tt.dates)
+			}
+
+			saved, err := taskService.store.Get(getTaskIDString(stale))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(saved.DailyProgress, "2024-01-02") {
+				t.Errorf("daily_progress = %s, 应包含2024-01-02", saved.DailyProgress)
+			}
+		})
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidQuery 任务列表的查询参数错误
+var ErrInvalidQuery = errors.New("无效的查询参数")
+
+// 分页大小
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// 没有日期的任务排序时视为最晚
+const noDateSortValue = "9999-12-31 23:59:59"
+
+// 可排序的字段及对应的SQL表达式，表达式不会为NULL，keyset分页可以直接比较
+var taskSortColumns = map[string]string{
+	"created_at":   "COALESCE(created_at, '')",
+	"updated_at":   "COALESCE(updated_at, '')",
+	"due_date":     "COALESCE(datetime(due_date), '" + noDateSortValue + "')",
+	"start_date":   "COALESCE(datetime(start_date), '" + noDateSortValue + "')",
+	"priority":     "COALESCE(priority, 0)",
+	"title":        "COALESCE(title, '')",
+	"category":     "COALESCE(category, '')",
+	"is_completed": "COALESCE(is_completed, 0)",
+}
+
+type taskSortKey struct {
+	Field string
+	Desc  bool
+}
+
+// TaskQuery GET /api/tasks 的筛选、排序和分页条件，空值表示不限制
+type TaskQuery struct {
+	OwnerIDs   []string
+	TaskID     string
+	Categories []string
+	Completed  *bool
+	Priorities []int
+	DueFrom    string
+	DueTo      string // 不含
+	StartFrom  string
+	StartTo    string // 不含
+	DeviceID   string
+	Sort       []taskSortKey
+	Limit      int
+	Offset     int
+	Cursor     []interface{} // 上一页最后一条的排序值和id
+	Filter     string        // 筛选表达式，见filterlang.go
+	TimeZone   *time.Location
+
+	filterSQL  string
+	filterArgs []interface{}
+}
+
+// 是否使用了新的查询参数，没有时保持旧接口返回任务数组
+func wantsTasksEnvelope(values url.Values) bool {
+	for _, key := range []string{"category", "completed", "priority", "due_from", "due_to",
+		"start_from", "start_to", "device_id", "sort", "limit", "offset", "cursor", "filter"} {
+		if _, ok := values[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func invalidQuery(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidQuery, fmt.Sprintf(format, args...))
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// 日期区间参数。只有日期的上界包含当天，即 due_to=2024-05-01 包括5月1日全天
+func parseRangeParam(name, value string, upper bool) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	t, ok := parseTaskTime(value)
+	if !ok {
+		return "", invalidQuery("%s: %s", name, value)
+	}
+	if upper && len(value) == len("2006-01-02") {
+		t = t.AddDate(0, 0, 1)
+	} else if upper {
+		t = t.Add(time.Second)
+	}
+	return t.UTC().Format(timeLayout), nil
+}
+
+// 排序参数: sort=priority:desc,due_date 或 sort=-priority,due_date
+func parseTaskSort(value string) ([]taskSortKey, error) {
+	if value == "" {
+		return []taskSortKey{{Field: "created_at", Desc: true}}, nil
+	}
+	var keys []taskSortKey
+	for _, item := range splitList(value) {
+		key := taskSortKey{Field: item}
+		if strings.HasPrefix(item, "-") {
+			key = taskSortKey{Field: item[1:], Desc: true}
+		} else if field, direction, ok := strings.Cut(item, ":"); ok {
+			key.Field = field
+			switch strings.ToLower(direction) {
+			case "asc":
+			case "desc":
+				key.Desc = true
+			default:
+				return nil, invalidQuery("排序方向: %s", direction)
+			}
+		}
+		if _, ok := taskSortColumns[key.Field]; !ok {
+			return nil, invalidQuery("不支持按%s排序", key.Field)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func formatTaskSort(keys []taskSortKey) string {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = key.Field
+		if key.Desc {
+			parts[i] = "-" + key.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// keyset游标: 排序方式和上一页最后一条的排序值，base64编码后返回给客户端
+type taskCursor struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v"`
+}
+
+func encodeTaskCursor(keys []taskSortKey, last *Task) string {
+	values := make([]interface{}, 0, len(keys)+1)
+	for _, key := range keys {
+		values = append(values, taskSortValue(last, key.Field))
+	}
+	values = append(values, getTaskIDString(last))
+	encoded, _ := json.Marshal(taskCursor{Sort: formatTaskSort(keys), Values: values})
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func decodeTaskCursor(value string, keys []taskSortKey) ([]interface{}, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, invalidQuery("cursor")
+	}
+	var cursor taskCursor
+	if err := json.Unmarshal(decoded, &cursor); err != nil || len(cursor.Values) != len(keys)+1 {
+		return nil, invalidQuery("cursor")
+	}
+	if cursor.Sort != formatTaskSort(keys) {
+		return nil, invalidQuery("cursor与sort不一致")
+	}
+	return cursor.Values, nil
+}
+
+// 与taskSortColumns中的SQL表达式取值一致
+func taskSortValue(task *Task, field string) interface{} {
+	switch field {
+	case "created_at":
+		return task.CreatedAt
+	case "updated_at":
+		return task.UpdatedAt
+	case "due_date", "start_date":
+		value := task.DueDate
+		if field == "start_date" {
+			value = task.StartDate
+		}
+		if t, ok := parseTaskTime(value); ok {
+			return t.UTC().Format(timeLayout)
+		}
+		return noDateSortValue
+	case "priority":
+		return task.Priority
+	case "title":
+		return task.Title
+	case "category":
+		return task.Category
+	case "is_completed":
+		if task.IsCompleted {
+			return 1
+		}
+		return 0
+	}
+	return nil
+}
+
+// 解析GET /api/tasks的查询参数
+func parseTaskQuery(values url.Values) (TaskQuery, error) {
+	q := TaskQuery{
+		Categories: splitList(values.Get("category")),
+		DeviceID:   values.Get("device_id"),
+		Filter:     values.Get("filter"),
+		Limit:      defaultPageSize,
+	}
+	var err error
+
+	if value := values.Get("completed"); value != "" {
+		completed, err := strconv.ParseBool(value)
+		if err != nil {
+			return q, invalidQuery("completed: %s", value)
+		}
+		q.Completed = &completed
+	}
+	for _, item := range splitList(values.Get("priority")) {
+		priority, err := strconv.Atoi(item)
+		if err != nil {
+			return q, invalidQuery("priority: %s", item)
+		}
+		q.Priorities = append(q.Priorities, priority)
+	}
+	if q.DueFrom, err = parseRangeParam("due_from", values.Get("due_from"), false); err != nil {
+		return q, err
+	}
+	if q.DueTo, err = parseRangeParam("due_to", values.Get("due_to"), true); err != nil {
+		return q, err
+	}
+	if q.StartFrom, err = parseRangeParam("start_from", values.Get("start_from"), false); err != nil {
+		return q, err
+	}
+	if q.StartTo, err = parseRangeParam("start_to", values.Get("start_to"), true); err != nil {
+		return q, err
+	}
+	if q.Sort, err = parseTaskSort(values.Get("sort")); err != nil {
+		return q, err
+	}
+	if q.TimeZone, err = parseTimeZone(values.Get("tz")); err != nil {
+		return q, err
+	}
+
+	if value := values.Get("limit"); value != "" {
+		if q.Limit, err = strconv.Atoi(value); err != nil || q.Limit <= 0 || q.Limit > maxPageSize {
+			return q, invalidQuery("limit必须在1到%d之间", maxPageSize)
+		}
+	}
+	if value := values.Get("offset"); value != "" {
+		if q.Offset, err = strconv.Atoi(value); err != nil || q.Offset < 0 {
+			return q, invalidQuery("offset: %s", value)
+		}
+	}
+	if value := values.Get("cursor"); value != "" {
+		if q.Offset > 0 {
+			return q, invalidQuery("cursor和offset不能同时使用")
+		}
+		if q.Cursor, err = decodeTaskCursor(value, q.Sort); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+// 编译筛选表达式，actorID用于 user:me
+func (q *TaskQuery) compileFilter(actorID string, now time.Time) error {
+	loc := q.TimeZone
+	if loc == nil {
+		loc = time.UTC
+	}
+	var err error
+	q.filterSQL, q.filterArgs, err = compileFilter(q.Filter, actorID, now, loc)
+	return err
+}
+
+// 筛选条件，不含分页
+func (q *TaskQuery) where() (string, []interface{}) {
+	conditions := []string{notDeleted, "user_id IN (?" + strings.Repeat(", ?", len(q.OwnerIDs)-1) + ")"}
+	args := make([]interface{}, 0, len(q.OwnerIDs))
+	for _, ownerID := range q.OwnerIDs {
+		args = append(args, ownerID)
+	}
+	if q.TaskID != "" {
+		conditions = append(conditions, "id = ?")
+		args = append(args, q.TaskID)
+	}
+
+	if len(q.Categories) > 0 {
+		conditions = append(conditions, "COALESCE(category, '') IN (?"+strings.Repeat(", ?", len(q.Categories)-1)+")")
+		for _, category := range q.Categories {
+			args = append(args, category)
+		}
+	}
+	if q.Completed != nil {
+		conditions = append(conditions, "COALESCE(is_completed, 0) = ?")
+		args = append(args, *q.Completed)
+	}
+	if len(q.Priorities) > 0 {
+		conditions = append(conditions, "COALESCE(priority, 0) IN (?"+strings.Repeat(", ?", len(q.Priorities)-1)+")")
+		for _, priority := range q.Priorities {
+			args = append(args, priority)
+		}
+	}
+	for _, bound := range []struct{ column, op, value string }{
+		{"due_date", ">=", q.DueFrom}, {"due_date", "<", q.DueTo},
+		{"start_date", ">=", q.StartFrom}, {"start_date", "<", q.StartTo},
+	} {
+		if bound.value != "" {
+			conditions = append(conditions, "datetime("+bound.column+") "+bound.op+" ?")
+			args = append(args, bound.value)
+		}
+	}
+	if q.DeviceID != "" {
+		conditions = append(conditions, "device_id = ?")
+		args = append(args, q.DeviceID)
+	}
+	if q.filterSQL != "" {
+		conditions = append(conditions, q.filterSQL)
+		args = append(args, q.filterArgs...)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// ORDER BY，最后按id保证顺序稳定
+func (q *TaskQuery) orderBy() string {
+	parts := make([]string, 0, len(q.Sort)+1)
+	for _, key := range q.Sort {
+		direction := " ASC"
+		if key.Desc {
+			direction = " DESC"
+		}
+		parts = append(parts, taskSortColumns[key.Field]+direction)
+	}
+	return strings.Join(append(parts, "id ASC"), ", ")
+}
+
+// keyset条件：排在游标之后的任务。
+// (a, b, id) 按各自方向展开为 a>? OR (a=? AND b<?) OR (a=? AND b=? AND id>?)
+func (q *TaskQuery) after() (string, []interface{}) {
+	columns := make([]string, 0, len(q.Sort)+1)
+	desc := make([]bool, 0, len(q.Sort)+1)
+	for _, key := range q.Sort {
+		columns = append(columns, taskSortColumns[key.Field])
+		desc = append(desc, key.Desc)
+	}
+	columns = append(columns, "id")
+	desc = append(desc, false)
+
+	var alternatives []string
+	var args []interface{}
+	for i := range columns {
+		var terms []string
+		for j := 0; j < i; j++ {
+			terms = append(terms, columns[j]+" = ?")
+			args = append(args, q.Cursor[j])
+		}
+		op := " > ?"
+		if desc[i] {
+			op = " < ?"
+		}
+		terms = append(terms, columns[i]+op)
+		args = append(args, q.Cursor[i])
+		alternatives = append(alternatives, "("+strings.Join(terms, " AND ")+")")
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")", args
+}
+
+// Query 按条件查询任务，返回当前页和符合条件的总数
+func (s *SQLiteTaskStore) Query(q TaskQuery) ([]Task, int, error) {
+	where, args := q.where()
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM tasks WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	if q.Cursor != nil {
+		after, afterArgs := q.after()
+		where += " AND " + after
+		args = append(args, afterArgs...)
+	}
+	where += " ORDER BY " + q.orderBy()
+	if q.Limit > 0 {
+		where += " LIMIT ? OFFSET ?"
+		args = append(args, q.Limit, q.Offset)
+	}
+
+	tasks, err := s.queryList(where, args...)
+	return tasks, total, err
+}
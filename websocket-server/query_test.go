@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTaskSort(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []taskSortKey
+		wantErr bool
+	}{
+		{value: "", want: []taskSortKey{{Field: "created_at", Desc: true}}},
+		{value: "priority", want: []taskSortKey{{Field: "priority"}}},
+		{value: "-priority,due_date", want: []taskSortKey{{Field: "priority", Desc: true}, {Field: "due_date"}}},
+		{value: "priority:DESC, title:asc", want: []taskSortKey{{Field: "priority", Desc: true}, {Field: "title"}}},
+		{value: "points", wantErr: true},
+		{value: "priority:up", wantErr: true},
+		{value: "-id", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTaskSort(tt.value)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidQuery) {
+				t.Errorf("parseTaskSort(%q) = %v, want ErrInvalidQuery", tt.value, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseTaskSort(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+		if err == nil && tt.value != "" {
+			if again, _ := parseTaskSort(formatTaskSort(got)); !reflect.DeepEqual(again, got) {
+				t.Errorf("formatTaskSort(%v) = %s 不能被重新解析", got, formatTaskSort(got))
+			}
+		}
+	}
+}
+
+func TestTaskCursor(t *testing.T) {
+	keys, _ := parseTaskSort("-priority,due_date,is_completed")
+	last := &Task{ID: "task_9", Priority: 2, DueDate: "2026-10-01T08:00:00+08:00", IsCompleted: true}
+
+	cursor := encodeTaskCursor(keys, last)
+	values, err := decodeTaskCursor(cursor, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 数字经过JSON后是float64，日期按UTC的timeLayout比较
+	want := []interface{}{float64(2), "2026-10-01 00:00:00", float64(1), "task_9"}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("decodeTaskCursor() = %v, want %v", values, want)
+	}
+
+	noDue := encodeTaskCursor([]taskSortKey{{Field: "due_date"}}, &Task{ID: "task_1"})
+	if values, _ := decodeTaskCursor(noDue, []taskSortKey{{Field: "due_date"}}); values[0] != noDateSortValue {
+		t.Errorf("没有截止时间的排序值 = %v", values[0])
+	}
+
+	otherKeys, _ := parseTaskSort("priority")
+	for name, value := range map[string]string{
+		"不是base64": "!!!",
+		"不是JSON":   "bm90IGpzb24",
+		"排序方式不一致":  cursor,
+		"排序值数量不一致": encodeTaskCursor(otherKeys[:0], last),
+	} {
+		check := keys
+		if name == "排序方式不一致" {
+			check = otherKeys
+		}
+		if _, err := decodeTaskCursor(value, check); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%s: decodeTaskCursor() = %v, want ErrInvalidQuery", name, err)
+		}
+	}
+}
+
+func TestParseRangeParam(t *testing.T) {
+	tests := []struct {
+		value string
+		upper bool
+		want  string
+	}{
+		{"2026-05-01", false, "2026-05-01 00:00:00"},
+		{"2026-05-01", true, "2026-05-02 00:00:00"},
+		{"2026-05-01T10:00:00+08:00", false, "2026-05-01 02:00:00"},
+		{"2026-05-01T10:00:00Z", true, "2026-05-01 10:00:01"},
+		{"", true, ""},
+	}
+	for _, tt := range tests {
+		if got, err := parseRangeParam("due_to", tt.value, tt.upper); err != nil || got != tt.want {
+			t.Errorf("parseRangeParam(%q, %v) = %q, %v, want %q", tt.value, tt.upper, got, err, tt.want)
+		}
+	}
+	if _, err := parseRangeParam("due_to", "下周", true); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("无效日期应该返回ErrInvalidQuery: %v", err)
+	}
+}
+
+func TestParseTaskQueryErrors(t *testing.T) {
+	keys, _ := parseTaskSort("")
+	cursor := encodeTaskCursor(keys, &Task{ID: "task_1"})
+	for _, query := range []string{
+		"completed=maybe",
+		"priority=high",
+		"limit=0",
+		"limit=501",
+		"offset=-1",
+		"offset=2&cursor=" + cursor,
+		"sort=priority&cursor=" + cursor,
+		"due_from=tomorrow",
+	} {
+		values, _ := url.ParseQuery(query)
+		if _, err := parseTaskQuery(values); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("parseTaskQuery(%s) = %v, want ErrInvalidQuery", query, err)
+		}
+	}
+}
+
+// 用游标翻页得到的顺序与一次查出全部的顺序相同，不重复也不遗漏
+func TestQueryCursorPagination(t *testing.T) {
+	openTestDB(t)
+	for _, task := range []Task{
+		{Title: "a", Priority: 2, DueDate: "2026-10-03T08:00:00Z"},
+		{Title: "b", Priority: 2, DueDate: "2026-10-01T08:00:00Z"},
+		{Title: "c", Priority: 2},
+		{Title: "d", Priority: 1, DueDate: "2026-10-02T16:00:00+08:00"},
+		{Title: "e", Priority: 1, DueDate: "2026-10-02T08:00:00Z"},
+		{Title: "f", Priority: 1},
+		{Title: "g", Priority: 0, DueDate: "2026-10-02T08:00:00Z", IsCompleted: true},
+	} {
+		createTestTask(t, "solo", task)
+	}
+
+	for _, sort := range []string{"-priority,due_date", "due_date,-title", "is_completed,-due_date", "title"} {
+		t.Run(sort, func(t *testing.T) {
+			all, err := parseTaskQuery(url.Values{"sort": {sort}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			all.OwnerIDs, all.Limit = []string{"solo"}, 0
+			expected, total, err := taskService.store.Query(all)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != 7 || len(expected) != 7 {
+				t.Fatalf("total = %d, len = %d", total, len(expected))
+			}
+
+			var titles []string
+			cursor := ""
+			for page := 0; page < 10; page++ {
+				values := url.Values{"sort": {sort}, "limit": {"2"}}
+				if cursor != "" {
+					values.Set("cursor", cursor)
+				}
+				q, err := parseTaskQuery(values)
+				if err != nil {
+					t.Fatal(err)
+				}
+				q.OwnerIDs = []string{"solo"}
+				tasks, _, err := taskService.store.Query(q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for i := range tasks {
+					titles = append(titles, tasks[i].Title)
+				}
+				if len(tasks) < q.Limit {
+					break
+				}
+				cursor = encodeTaskCursor(q.Sort, &tasks[len(tasks)-1])
+			}
+
+			var want []string
+			for i := range expected {
+				want = append(want, expected[i].Title)
+			}
+			if strings.Join(titles, ",") != strings.Join(want, ",") {
+				t.Errorf("翻页结果 = %v, want %v", titles, want)
+			}
+		})
+	}
+}
+
+func TestQuerySortOrder(t *testing.T) {
+	openTestDB(t)
+	for _, task := range []Task{
+		{Title: "晚", Priority: 1, DueDate: "2026-10-02T08:00:00Z"},
+		{Title: "无日期", Priority: 1},
+		{Title: "早", Priority: 1, DueDate: "2026-10-02T15:00:00+08:00"},
+		{Title: "高", Priority: 3},
+	} {
+		createTestTask(t, "solo", task)
+	}
+	q, _ := parseTaskQuery(url.Values{"sort": {"-priority,due_date"}})
+	q.OwnerIDs = []string{"solo"}
+	tasks, _, err := taskService.store.Query(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for i := range tasks {
+		titles = append(titles, tasks[i].Title)
+	}
+	// 截止时间按UTC比较，没有截止时间的排在最后
+	if got, want := strings.Join(titles, ","), "高,早,晚,无日期"; got != want {
+		t.Errorf("排序 = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,774 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	occurrenceDateLayout = "2006-01-02"
+	// 单次查询最多展开的天数，避免一次返回过多的重复实例
+	maxExpandWindow = 400 * 24 * time.Hour
+	// 展开时最多遍历的周期数，防止异常规则导致死循环；超过查询窗口后即停止，正常不会达到
+	maxRRulePeriods = 20000
+)
+
+var (
+	// ErrNotRecurring 任务不是重复任务
+	ErrNotRecurring = errors.New("任务不是重复任务")
+	// ErrNoSuchOccurrence 该日期不是重复任务的实例
+	ErrNoSuchOccurrence = errors.New("该日期没有这个重复任务")
+	// ErrInvalidRecurrence 重复规则或排除日期无效
+	ErrInvalidRecurrence = errors.New("无效的重复规则")
+)
+
+var rruleWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// 以周一为一周开始的偏移 (WKST=MO)
+func weekdayOffset(d time.Weekday) int {
+	return (int(d) + 6) % 7
+}
+
+type weekdayNum struct {
+	N   int // 0表示每个该星期几；MONTHLY时1表示第一个，-1表示最后一个
+	Day time.Weekday
+}
+
+// RRule RFC 5545 RRULE子集: FREQ=DAILY|WEEKLY|MONTHLY, INTERVAL, BYDAY, BYMONTHDAY, COUNT, UNTIL
+type RRule struct {
+	Freq       string
+	Interval   int
+	ByDay      []weekdayNum
+	ByMonthDay []int
+	Count      int
+	Until      time.Time
+	// UNTIL的形式: DATE（如20261031）包含当天全天；不带Z的本地时间按dtstart的时区解释
+	UntilIsDate   bool
+	UntilFloating bool
+}
+
+func parseRRule(value string) (*RRule, error) {
+	value = strings.TrimSpace(value)
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "RRULE:"), "rrule:")
+	if value == "" {
+		return nil, fmt.Errorf("重复规则为空")
+	}
+
+	rule := &RRule{Interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("无效的重复规则片段: %s", part)
+		}
+		key, val := strings.ToUpper(strings.TrimSpace(kv[0])), strings.ToUpper(strings.TrimSpace(kv[1]))
+
+		switch key {
+		case "FREQ":
+			if val != "DAILY" && val != "WEEKLY" && val != "MONTHLY" {
+				return nil, fmt.Errorf("不支持的FREQ: %s", val)
+			}
+			rule.Freq = val
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("无效的INTERVAL: %s", val)
+			}
+			rule.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("无效的COUNT: %s", val)
+			}
+			rule.Count = n
+		case "UNTIL":
+			until, err := parseRRuleTime(val)
+			if err != nil {
+				return nil, fmt.Errorf("无效的UNTIL: %s", val)
+			}
+			rule.Until = until
+			rule.UntilIsDate = len(val) == len("20060102") || len(val) == len(occurrenceDateLayout)
+			rule.UntilFloating = !rule.UntilIsDate && len(val) == len("20060102T150405")
+		case "BYDAY":
+			for _, item := range strings.Split(val, ",") {
+				if len(item) < 2 {
+					return nil, fmt.Errorf("无效的BYDAY: %s", item)
+				}
+				day, ok := rruleWeekdays[item[len(item)-2:]]
+				if !ok {
+					return nil, fmt.Errorf("无效的BYDAY: %s", item)
+				}
+				n := 0
+				if prefix := item[:len(item)-2]; prefix != "" {
+					var err error
+					n, err = strconv.Atoi(prefix)
+					if err != nil || n == 0 || n > 5 || n < -5 {
+						return nil, fmt.Errorf("无效的BYDAY: %s", item)
+					}
+				}
+				rule.ByDay = append(rule.ByDay, weekdayNum{N: n, Day: day})
+			}
+		case "BYMONTHDAY":
+			for _, item := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(item)
+				if err != nil || n == 0 || n > 31 || n < -31 {
+					return nil, fmt.Errorf("无效的BYMONTHDAY: %s", item)
+				}
+				rule.ByMonthDay = append(rule.ByMonthDay, n)
+			}
+		case "WKST":
+			if val != "MO" {
+				return nil, fmt.Errorf("只支持WKST=MO")
+			}
+		default:
+			return nil, fmt.Errorf("不支持的重复规则属性: %s", key)
+		}
+	}
+
+	if rule.Freq == "" {
+		return nil, fmt.Errorf("重复规则缺少FREQ")
+	}
+	if rule.Count > 0 && !rule.Until.IsZero() {
+		return nil, fmt.Errorf("COUNT和UNTIL不能同时使用")
+	}
+	for _, wd := range rule.ByDay {
+		if wd.N != 0 && rule.Freq != "MONTHLY" {
+			return nil, fmt.Errorf("只有MONTHLY支持带序号的BYDAY")
+		}
+	}
+	if len(rule.ByMonthDay) > 0 && rule.Freq != "MONTHLY" {
+		return nil, fmt.Errorf("只有MONTHLY支持BYMONTHDAY")
+	}
+	return rule, nil
+}
+
+// UNTIL/EXDATE格式: 20250131, 20250131T080000Z，也兼容2025-01-31
+func parseRRuleTime(value string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102", occurrenceDateLayout} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	if t, ok := parseTaskTime(value); ok {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("无效的时间: %s", value)
+}
+
+// 解析逗号分隔的排除日期，返回YYYY-MM-DD集合
+func parseExDates(value string) (map[string]bool, error) {
+	dates := map[string]bool{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(item), "EXDATE:"))
+		if item == "" {
+			continue
+		}
+		t, err := parseRRuleTime(item)
+		if err != nil {
+			return nil, err
+		}
+		dates[t.Format(occurrenceDateLayout)] = true
+	}
+	return dates, nil
+}
+
+func daysIn(year int, month time.Month, loc *time.Location) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, loc).Day()
+}
+
+// 第k个周期的开始: DAILY为当天，WEEKLY为所在周的周一，MONTHLY为当月1日，周期内的候选都不早于它
+func (r *RRule) periodStart(dtstart time.Time, k int) time.Time {
+	day := time.Date(dtstart.Year(), dtstart.Month(), dtstart.Day(), 0, 0, 0, 0, dtstart.Location())
+	switch r.Freq {
+	case "WEEKLY":
+		return day.AddDate(0, 0, -weekdayOffset(dtstart.Weekday())+7*r.Interval*k)
+	case "MONTHLY":
+		return time.Date(dtstart.Year(), dtstart.Month()+time.Month(k*r.Interval), 1, 0, 0, 0, 0, dtstart.Location())
+	}
+	return day.AddDate(0, 0, k*r.Interval)
+}
+
+// 第k个周期内的候选时间，已按时间排序
+func (r *RRule) periodCandidates(dtstart time.Time, k int) []time.Time {
+	h, m, s := dtstart.Clock()
+	loc := dtstart.Location()
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, h, m, s, 0, loc)
+	}
+
+	switch r.Freq {
+	case "DAILY":
+		return []time.Time{dtstart.AddDate(0, 0, k*r.Interval)}
+
+	case "WEEKLY":
+		weekStart := dtstart.AddDate(0, 0, -weekdayOffset(dtstart.Weekday())+7*r.Interval*k)
+		if len(r.ByDay) == 0 {
+			return []time.Time{weekStart.AddDate(0, 0, weekdayOffset(dtstart.Weekday()))}
+		}
+		offsets := []int{}
+		for _, wd := range r.ByDay {
+			offsets = append(offsets, weekdayOffset(wd.Day))
+		}
+		sort.Ints(offsets)
+		candidates := []time.Time{}
+		for i, offset := range offsets {
+			if i > 0 && offsets[i-1] == offset {
+				continue
+			}
+			candidates = append(candidates, weekStart.AddDate(0, 0, offset))
+		}
+		return candidates
+
+	case "MONTHLY":
+		first := time.Date(dtstart.Year(), dtstart.Month()+time.Month(k*r.Interval), 1, 0, 0, 0, 0, loc)
+		year, month := first.Year(), first.Month()
+		dim := daysIn(year, month, loc)
+
+		days := map[int]bool{}
+		switch {
+		case len(r.ByMonthDay) > 0:
+			for _, d := range r.ByMonthDay {
+				if d < 0 {
+					d = dim + d + 1
+				}
+				if d >= 1 && d <= dim {
+					days[d] = true
+				}
+			}
+		case len(r.ByDay) > 0:
+			for _, wd := range r.ByDay {
+				matches := []int{}
+				for d := 1; d <= dim; d++ {
+					if time.Date(year, month, d, 0, 0, 0, 0, loc).Weekday() == wd.Day {
+						matches = append(matches, d)
+					}
+				}
+				switch {
+				case wd.N == 0:
+					for _, d := range matches {
+						days[d] = true
+					}
+				case wd.N > 0 && wd.N <= len(matches):
+					days[matches[wd.N-1]] = true
+				case wd.N < 0 && -wd.N <= len(matches):
+					days[matches[len(matches)+wd.N]] = true
+				}
+			}
+		default:
+			// 没有31号的月份跳过 (RFC 5545)
+			if dtstart.Day() <= dim {
+				days[dtstart.Day()] = true
+			}
+		}
+
+		sorted := make([]int, 0, len(days))
+		for d := range days {
+			sorted = append(sorted, d)
+		}
+		sort.Ints(sorted)
+		candidates := make([]time.Time, 0, len(sorted))
+		for _, d := range sorted {
+			candidates = append(candidates, at(year, month, d))
+		}
+		return candidates
+	}
+	return nil
+}
+
+// 在dtstart的时区中解释UNTIL: DATE形式截止到当天结束（包含当天的实例）
+func (r *RRule) untilIn(loc *time.Location) time.Time {
+	u := r.Until
+	switch {
+	case r.UntilIsDate:
+		return time.Date(u.Year(), u.Month(), u.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, 1).Add(-time.Nanosecond)
+	case r.UntilFloating:
+		return time.Date(u.Year(), u.Month(), u.Day(), u.Hour(), u.Minute(), u.Second(), 0, loc)
+	}
+	return u
+}
+
+// Between 展开[from, to)窗口内的实例。COUNT/UNTIL从dtstart开始计算，
+// EXDATE在计数之后排除，与RFC 5545一致
+func (r *RRule) Between(dtstart, from, to time.Time, exdates map[string]bool) []time.Time {
+	occurrences := []time.Time{}
+	count := 0
+	until := r.untilIn(dtstart.Location())
+	for k := 0; k < maxRRulePeriods; k++ {
+		// 某些周期可能没有候选（如每年二月的30号），不能只靠候选时间判断是否越过窗口
+		if !r.periodStart(dtstart, k).Before(to) {
+			return occurrences
+		}
+		for _, t := range r.periodCandidates(dtstart, k) {
+			if t.Before(dtstart) {
+				continue
+			}
+			count++
+			if r.Count > 0 && count > r.Count {
+				return occurrences
+			}
+			if !r.Until.IsZero() && t.After(until) {
+				return occurrences
+			}
+			if !t.Before(to) {
+				return occurrences
+			}
+			if !t.Before(from) && !exdates[t.Format(occurrenceDateLayout)] {
+				occurrences = append(occurrences, t)
+			}
+		}
+	}
+	return occurrences
+}
+
+// 重复任务的起始时间: 优先开始时间，其次截止时间。设置了时区时转换到该时区，
+// 按该时区的日期和星期展开（客户端提交的多是UTC时间，UTC+8早上的任务在UTC是前一天）
+func taskSeriesStart(task *Task) (time.Time, bool) {
+	t, ok := parseTaskTime(task.StartDate)
+	if !ok {
+		if t, ok = parseTaskTime(task.DueDate); !ok {
+			return time.Time{}, false
+		}
+	}
+	if task.TimeZone != "" {
+		if loc, err := parseTimeZone(task.TimeZone); err == nil {
+			t = t.In(loc)
+		}
+	}
+	return t, true
+}
+
+// 按BYMONTHDAY只落在短月份的规则永远不会产生实例，例如从二月开始INTERVAL=12的BYMONTHDAY=30
+func monthlyRuleHasOccurrences(rule *RRule, dtstart time.Time) bool {
+	if rule.Freq != "MONTHLY" || len(rule.ByMonthDay) == 0 {
+		return true
+	}
+	longest := 0
+	for k := 0; k < 12; k++ {
+		month := time.Month((int(dtstart.Month())-1+k*rule.Interval)%12 + 1)
+		// 二月按闰年计算
+		if days := daysIn(2024, month, time.UTC); days > longest {
+			longest = days
+		}
+	}
+	for _, d := range rule.ByMonthDay {
+		if d < 0 {
+			d = -d
+		}
+		if d <= longest {
+			return true
+		}
+	}
+	return false
+}
+
+// 保存前校验重复规则和排除日期
+func validateRecurrence(task *Task) error {
+	if task.RRule == "" {
+		return nil
+	}
+	rule, err := parseRRule(task.RRule)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRecurrence, err)
+	}
+	if _, err := parseExDates(task.ExDates); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRecurrence, err)
+	}
+	if _, err := parseTimeZone(task.TimeZone); err != nil {
+		return fmt.Errorf("%w: 未知时区%q", ErrInvalidRecurrence, task.TimeZone)
+	}
+	dtstart, ok := taskSeriesStart(task)
+	if !ok {
+		return fmt.Errorf("%w: 重复任务需要开始时间或截止时间", ErrInvalidRecurrence)
+	}
+	if !monthlyRuleHasOccurrences(rule, dtstart) {
+		return fmt.Errorf("%w: 重复规则不会产生任何实例", ErrInvalidRecurrence)
+	}
+	return nil
+}
+
+// Occurrence 重复任务某一天实例的完成状态
+type Occurrence struct {
+	TaskID          string
+	Date            string
+	IsCompleted     bool
+	ApprovalStatus  string
+	ApprovalComment string
+	CompletedBy     string
+	CompletedAt     string
+	ReviewedBy      string
+	ReviewedAt      string
+}
+
+// 查询任务所有实例的完成状态，按日期索引
+func loadOccurrences(taskID string) (map[string]*Occurrence, error) {
+	rows, err := db.Query(`SELECT task_id, occurrence_date, COALESCE(is_completed, 0), COALESCE(approval_status, ''),
+		COALESCE(approval_comment, ''), COALESCE(completed_by, ''), COALESCE(completed_at, ''),
+		COALESCE(reviewed_by, ''), COALESCE(reviewed_at, '')
+		FROM task_occurrences WHERE task_id = ?`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	occurrences := map[string]*Occurrence{}
+	for rows.Next() {
+		var o Occurrence
+		if err := rows.Scan(&o.TaskID, &o.Date, &o.IsCompleted, &o.ApprovalStatus, &o.ApprovalComment,
+			&o.CompletedBy, &o.CompletedAt, &o.ReviewedBy, &o.ReviewedAt); err != nil {
+			return nil, err
+		}
+		occurrences[o.Date] = &o
+	}
+	return occurrences, rows.Err()
+}
+
+func saveOccurrence(o *Occurrence) error {
+	_, err := db.Exec(`INSERT INTO task_occurrences (task_id, occurrence_date, is_completed, approval_status,
+		approval_comment, completed_by, completed_at, reviewed_by, reviewed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (task_id, occurrence_date) DO UPDATE SET
+		is_completed = excluded.is_completed, approval_status = excluded.approval_status,
+		approval_comment = excluded.approval_comment, completed_by = excluded.completed_by,
+		completed_at = excluded.completed_at, reviewed_by = excluded.reviewed_by, reviewed_at = excluded.reviewed_at`,
+		o.TaskID, o.Date, o.IsCompleted, o.ApprovalStatus, o.ApprovalComment,
+		o.CompletedBy, o.CompletedAt, o.ReviewedBy, o.ReviewedAt)
+	return err
+}
+
+// from到to相隔的日历天数，以from的时区计算日期
+func calendarDaysBetween(from, to time.Time) int {
+	to = to.In(from.Location())
+	a := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	b := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(b.Sub(a).Hours() / 24)
+}
+
+// 生成某次实例的任务视图：时间平移到该实例，完成状态取自实例记录
+func occurrenceView(series *Task, start time.Time, o *Occurrence) Task {
+	view := *series
+	view.OccurrenceDate = start.Format(occurrenceDateLayout)
+
+	// 按日历日平移，跨夏令时切换也保持原来的钟点
+	seriesStart, _ := taskSeriesStart(series)
+	days := calendarDaysBetween(seriesStart, start)
+	// 在展开所用的时区中平移，结果仍按原来的时区格式化
+	if t, ok := parseTaskTime(series.StartDate); ok {
+		view.StartDate = t.In(start.Location()).AddDate(0, 0, days).In(t.Location()).Format(time.RFC3339)
+	}
+	if t, ok := parseTaskTime(series.DueDate); ok {
+		view.DueDate = t.In(start.Location()).AddDate(0, 0, days).In(t.Location()).Format(time.RFC3339)
+	}
+
+	view.IsCompleted = false
+	view.ApprovalStatus = ApprovalNone
+	view.ApprovalComment = ""
+	view.CompletionRequestedAt = ""
+	view.ReviewedBy = ""
+	view.ReviewedAt = ""
+	if o != nil {
+		view.IsCompleted = o.IsCompleted
+		view.ApprovalStatus = o.ApprovalStatus
+		view.ApprovalComment = o.ApprovalComment
+		if o.ApprovalStatus == ApprovalPending {
+			view.CompletionRequestedAt = o.CompletedAt
+		}
+		view.ReviewedBy = o.ReviewedBy
+		view.ReviewedAt = o.ReviewedAt
+	}
+	return view
+}
+
+// 展开任务在[from, to)内的实例；非重复任务在窗口内时原样返回
+func expandTask(task *Task, from, to time.Time) ([]Task, error) {
+	if task.RRule == "" {
+		start, ok := taskSeriesStart(task)
+		if !ok {
+			return nil, nil
+		}
+		end := start
+		if due, ok := parseTaskTime(task.DueDate); ok && due.After(start) {
+			end = due
+		}
+		if end.Before(from) || !start.Before(to) {
+			return nil, nil
+		}
+		return []Task{*task}, nil
+	}
+
+	rule, err := parseRRule(task.RRule)
+	if err != nil {
+		return nil, err
+	}
+	exdates, err := parseExDates(task.ExDates)
+	if err != nil {
+		return nil, err
+	}
+	dtstart, ok := taskSeriesStart(task)
+	if !ok {
+		return nil, nil
+	}
+
+	starts := rule.Between(dtstart, from, to, exdates)
+	if len(starts) == 0 {
+		return nil, nil
+	}
+	occurrences, err := loadOccurrences(getTaskIDString(task))
+	if err != nil {
+		return nil, err
+	}
+
+	views := make([]Task, 0, len(starts))
+	for _, start := range starts {
+		views = append(views, occurrenceView(task, start, occurrences[start.Format(occurrenceDateLayout)]))
+	}
+	return views, nil
+}
+
+// 展开任务列表在窗口内的所有实例，按开始时间排序
+func expandTasks(tasks []Task, from, to time.Time) ([]Task, error) {
+	expanded := []Task{}
+	for i := range tasks {
+		views, err := expandTask(&tasks[i], from, to)
+		if err != nil {
+			log.Printf("⚠️ 展开重复任务失败: %s, %v", tasks[i].Title, err)
+			continue
+		}
+		expanded = append(expanded, views...)
+	}
+	sort.SliceStable(expanded, func(i, j int) bool {
+		a, _ := taskSeriesStart(&expanded[i])
+		b, _ := taskSeriesStart(&expanded[j])
+		return a.Before(b)
+	})
+	return expanded, nil
+}
+
+// 解析查询窗口参数from/to，to缺省为from之后7天
+func parseExpandWindow(fromValue, toValue string) (time.Time, time.Time, error) {
+	from, ok := parseTaskTime(fromValue)
+	if !ok {
+		return time.Time{}, time.Time{}, fmt.Errorf("无效的from: %s", fromValue)
+	}
+	to := from.AddDate(0, 0, 7)
+	if toValue != "" {
+		if to, ok = parseTaskTime(toValue); !ok {
+			return time.Time{}, time.Time{}, fmt.Errorf("无效的to: %s", toValue)
+		}
+	}
+	if !to.After(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to必须晚于from")
+	}
+	if to.Sub(from) > maxExpandWindow {
+		return time.Time{}, time.Time{}, fmt.Errorf("查询窗口不能超过%d天", int(maxExpandWindow.Hours()/24))
+	}
+	return from, to, nil
+}
+
+// 找到重复任务在某天的实例开始时间
+func findOccurrenceStart(task *Task, date string) (time.Time, error) {
+	if task.RRule == "" {
+		return time.Time{}, ErrNotRecurring
+	}
+	day, err := time.Parse(occurrenceDateLayout, date)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("无效的日期: %s", date)
+	}
+	rule, err := parseRRule(task.RRule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	exdates, err := parseExDates(task.ExDates)
+	if err != nil {
+		return time.Time{}, err
+	}
+	dtstart, ok := taskSeriesStart(task)
+	if !ok {
+		return time.Time{}, ErrNoSuchOccurrence
+	}
+
+	// 以dtstart所在时区的当天作为窗口
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, dtstart.Location())
+	starts := rule.Between(dtstart, from, from.AddDate(0, 0, 1), exdates)
+	if len(starts) == 0 {
+		return time.Time{}, ErrNoSuchOccurrence
+	}
+	return starts[0], nil
+}
+
+// SetOccurrenceCompleted 完成或取消完成重复任务的某一次实例，不影响整个系列。
+// 孩子完成时同样进入待审批状态
+func (s *TaskService) SetOccurrenceCompleted(actorID, taskID, date string, completed bool) (*Task, error) {
+	task, err := s.GetTaskForUser(actorID, taskID)
+	if err != nil {
+		return nil, err
+	}
+	start, err := findOccurrenceStart(task, date)
+	if err != nil {
+		return nil, err
+	}
+
+	// 本人（包括孩子）或能管理该任务的家长可以修改实例完成状态
+	if actorID != task.UserID {
+		if err := authorizeTaskCreate(actorID, task.UserID); err != nil {
+			return nil, err
+		}
+	}
+
+	occurrences, err := loadOccurrences(taskID)
+	if err != nil {
+		return nil, err
+	}
+	o := occurrences[date]
+	if o == nil {
+		o = &Occurrence{TaskID: taskID, Date: date}
+	}
+	wasCompleted := o.IsCompleted
+
+	child, err := isChild(actorID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().Format(timeLayout)
+	completionRequested := false
+	switch {
+	case completed && child:
+		if o.ApprovalStatus != ApprovalPending && !o.IsCompleted {
+			o.ApprovalStatus = ApprovalPending
+			o.ApprovalComment = ""
+			o.CompletedBy = actorID
+			o.CompletedAt = now
+			completionRequested = true
+		}
+	case completed:
+		if o.ApprovalStatus == ApprovalPending {
+			o.ApprovalStatus = ApprovalApproved
+			o.ReviewedBy = actorID
+			o.ReviewedAt = now
+		}
+		if !o.IsCompleted {
+			o.CompletedBy = actorID
+			o.CompletedAt = now
+		}
+		o.IsCompleted = true
+	default:
+		o.IsCompleted = false
+		o.ApprovalStatus = ApprovalNone
+		o.CompletedBy = ""
+		o.CompletedAt = ""
+	}
+
+	if err := saveOccurrence(o); err != nil {
+		return nil, err
+	}
+
+	view := occurrenceView(task, start, o)
+	log.Printf("🔁 重复任务实例更新: %s @ %s, completed=%v, approval=%s", task.Title, date, o.IsCompleted, o.ApprovalStatus)
+	broadcastTaskChange("occurrence_updated", &view)
+	if completionRequested {
+		broadcastToParents("completion_requested", &view)
+	}
+	settleTaskPoints(actorID, &view, wasCompleted)
+	return &view, nil
+}
+
+// 家长审批重复任务某次实例的完成申请
+func (s *TaskService) reviewOccurrence(actorID string, task *Task, date string, approve bool, comment string) (*Task, error) {
+	start, err := findOccurrenceStart(task, date)
+	if err != nil {
+		return nil, err
+	}
+	occurrences, err := loadOccurrences(getTaskIDString(task))
+	if err != nil {
+		return nil, err
+	}
+	o := occurrences[date]
+	if o == nil || o.ApprovalStatus != ApprovalPending {
+		return nil, ErrNoPendingCompletion
+	}
+
+	wasCompleted := o.IsCompleted
+	o.IsCompleted = approve
+	o.ApprovalStatus = ApprovalRejected
+	if approve {
+		o.ApprovalStatus = ApprovalApproved
+	}
+	o.ApprovalComment = comment
+	o.ReviewedBy = actorID
+	o.ReviewedAt = time.Now().UTC().Format(timeLayout)
+	if err := saveOccurrence(o); err != nil {
+		return nil, err
+	}
+
+	view := occurrenceView(task, start, o)
+	broadcastTaskChange("occurrence_updated", &view)
+	if approve {
+		broadcastTaskChange("completion_approved", &view)
+	} else {
+		broadcastTaskChange("completion_rejected", &view)
+	}
+	settleTaskPoints(actorID, &view, wasCompleted)
+	return &view, nil
+}
+
+// 重复任务实例REST处理器: PUT /api/tasks/{id}/occurrences/{date}，body为 {"is_completed": true}
+func updateOccurrenceHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	req := struct {
+		IsCompleted *bool `json:"is_completed"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	completed := req.IsCompleted == nil || *req.IsCompleted
+
+	view, err := taskService.SetOccurrenceCompleted(userFromContext(r.Context()).ID, vars["id"], vars["date"], completed)
+	if err != nil {
+		writeOccurrenceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(view)
+}
+
+func writeOccurrenceError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrNotRecurring, ErrNoSuchOccurrence:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case ErrNoPendingCompletion:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		writeTaskError(w, err)
+	}
+}
+
+// WebSocket消息 complete_occurrence，data为 {id, occurrence_date, is_completed}
+func handleCompleteOccurrence(client *Client, data interface{}) (*Task, error) {
+	taskMap, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidMessage
+	}
+
+	completed := true
+	if _, ok := taskMap["is_completed"]; ok {
+		completed = getBool(taskMap, "is_completed")
+	}
+
+	return taskService.SetOccurrenceCompleted(client.userID, getString(taskMap, "id"),
+		getString(taskMap, "occurrence_date"), completed)
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRRule(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr bool
+		check   func(r *RRule) bool
+	}{
+		{value: "FREQ=DAILY", check: func(r *RRule) bool { return r.Freq == "DAILY" && r.Interval == 1 }},
+		{value: "RRULE:freq=weekly;interval=2;byday=MO,WE", check: func(r *RRule) bool {
+			return r.Freq == "WEEKLY" && r.Interval == 2 && len(r.ByDay) == 2 && r.ByDay[1].Day == time.Wednesday
+		}},
+		{value: "FREQ=MONTHLY;BYDAY=-1FR", check: func(r *RRule) bool {
+			return len(r.ByDay) == 1 && r.ByDay[0].N == -1 && r.ByDay[0].Day == time.Friday
+		}},
+		{value: "FREQ=MONTHLY;BYMONTHDAY=1,-1", check: func(r *RRule) bool { return len(r.ByMonthDay) == 2 }},
+		{value: "FREQ=DAILY;COUNT=3", check: func(r *RRule) bool { return r.Count == 3 }},
+		{value: "FREQ=DAILY;UNTIL=20261031", check: func(r *RRule) bool { return r.UntilIsDate && !r.UntilFloating }},
+		{value: "FREQ=DAILY;UNTIL=2026-10-31", check: func(r *RRule) bool { return r.UntilIsDate }},
+		{value: "FREQ=DAILY;UNTIL=20261031T080000", check: func(r *RRule) bool { return r.UntilFloating && !r.UntilIsDate }},
+		{value: "FREQ=DAILY;UNTIL=20261031T080000Z", check: func(r *RRule) bool { return !r.UntilFloating && !r.UntilIsDate }},
+		{value: "FREQ=DAILY;WKST=MO", check: func(r *RRule) bool { return r.Freq == "DAILY" }},
+		{value: "", wantErr: true},
+		{value: "INTERVAL=2", wantErr: true},
+		{value: "FREQ=YEARLY", wantErr: true},
+		{value: "FREQ=DAILY;INTERVAL=0", wantErr: true},
+		{value: "FREQ=DAILY;COUNT=-1", wantErr: true},
+		{value: "FREQ=DAILY;UNTIL=tomorrow", wantErr: true},
+		{value: "FREQ=DAILY;COUNT=2;UNTIL=20261031", wantErr: true},
+		{value: "FREQ=WEEKLY;BYDAY=1MO", wantErr: true},
+		{value: "FREQ=WEEKLY;BYDAY=XX", wantErr: true},
+		{value: "FREQ=MONTHLY;BYDAY=6MO", wantErr: true},
+		{value: "FREQ=DAILY;BYMONTHDAY=1", wantErr: true},
+		{value: "FREQ=MONTHLY;BYMONTHDAY=32", wantErr: true},
+		{value: "FREQ=DAILY;WKST=SU", wantErr: true},
+		{value: "FREQ=DAILY;BYHOUR=8", wantErr: true},
+		{value: "FREQ", wantErr: true},
+	}
+	for _, tt := range tests {
+		rule, err := parseRRule(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseRRule(%q) 应该返回错误", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRRule(%q) 出错: %v", tt.value, err)
+			continue
+		}
+		if !tt.check(rule) {
+			t.Errorf("parseRRule(%q) = %+v", tt.value, rule)
+		}
+	}
+}
+
+func TestParseExDates(t *testing.T) {
+	dates, err := parseExDates("20261003, EXDATE:2026-10-05,20261007T080000Z,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, date := range []string{"2026-10-03", "2026-10-05", "2026-10-07"} {
+		if !dates[date] {
+			t.Errorf("缺少排除日期 %s: %v", date, dates)
+		}
+	}
+	if len(dates) != 3 {
+		t.Errorf("排除日期数量 = %d, want 3", len(dates))
+	}
+	if _, err := parseExDates("2026-13-01"); err == nil {
+		t.Error("无效的排除日期应该返回错误")
+	}
+}
+
+func formatOccurrences(times []time.Time) string {
+	values := make([]string, len(times))
+	for i, t := range times {
+		values[i] = t.Format("2006-01-02 15:04")
+	}
+	return strings.Join(values, ",")
+}
+
+func TestRRuleBetween(t *testing.T) {
+	utc8 := time.FixedZone("UTC+8", 8*3600)
+	// 2026-10-01是周四
+	dtstart := time.Date(2026, 10, 1, 18, 0, 0, 0, utc8)
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, utc8)
+	to := time.Date(2026, 12, 31, 0, 0, 0, 0, utc8)
+
+	tests := []struct {
+		name    string
+		rule    string
+		exdates string
+		from    time.Time
+		want    string
+	}{
+		{
+			name: "每天COUNT",
+			rule: "FREQ=DAILY;COUNT=3",
+			want: "2026-10-01 18:00,2026-10-02 18:00,2026-10-03 18:00",
+		},
+		{
+			name: "隔天",
+			rule: "FREQ=DAILY;INTERVAL=2;COUNT=3",
+			want: "2026-10-01 18:00,2026-10-03 18:00,2026-10-05 18:00",
+		},
+		{
+			name: "DATE形式的UNTIL包含当天",
+			rule: "FREQ=DAILY;UNTIL=20261003",
+			want: "2026-10-01 18:00,2026-10-02 18:00,2026-10-03 18:00",
+		},
+		{
+			name: "带连字符的DATE形式UNTIL",
+			rule: "FREQ=DAILY;UNTIL=2026-10-02",
+			want: "2026-10-01 18:00,2026-10-02 18:00",
+		},
+		{
+			name: "UTC时间的UNTIL不包含之后的实例",
+			rule: "FREQ=DAILY;UNTIL=20261003T050000Z",
+			want: "2026-10-01 18:00,2026-10-02 18:00",
+		},
+		{
+			name: "本地时间的UNTIL按dtstart时区解释",
+			rule: "FREQ=DAILY;UNTIL=20261003T180000",
+			want: "2026-10-01 18:00,2026-10-02 18:00,2026-10-03 18:00",
+		},
+		{
+			name: "每周一三，不早于dtstart",
+			rule: "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=4",
+			want: "2026-10-05 18:00,2026-10-07 18:00,2026-10-12 18:00,2026-10-14 18:00",
+		},
+		{
+			name: "没有BYDAY时按dtstart的星期几",
+			rule: "FREQ=WEEKLY;INTERVAL=2;COUNT=3",
+			want: "2026-10-01 18:00,2026-10-15 18:00,2026-10-29 18:00",
+		},
+		{
+			name: "每月最后一个周五",
+			rule: "FREQ=MONTHLY;BYDAY=-1FR;COUNT=3",
+			want: "2026-10-30 18:00,2026-11-27 18:00,2026-12-25 18:00",
+		},
+		{
+			name: "每月最后一天",
+			rule: "FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=3",
+			want: "2026-10-31 18:00,2026-11-30 18:00",
+		},
+		{
+			name:    "EXDATE在计数之后排除",
+			rule:    "FREQ=DAILY;COUNT=3",
+			exdates: "20261002",
+			want:    "2026-10-01 18:00,2026-10-03 18:00",
+		},
+		{
+			name: "COUNT从dtstart开始计算，与窗口无关",
+			rule: "FREQ=DAILY;COUNT=3",
+			from: time.Date(2026, 10, 2, 0, 0, 0, 0, utc8),
+			want: "2026-10-02 18:00,2026-10-03 18:00",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := parseRRule(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			exdates, err := parseExDates(tt.exdates)
+			if err != nil {
+				t.Fatal(err)
+			}
+			windowFrom := from
+			if !tt.from.IsZero() {
+				windowFrom = tt.from
+			}
+			if got := formatOccurrences(rule.Between(dtstart, windowFrom, to, exdates)); got != tt.want {
+				t.Errorf("Between() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRRuleBetweenMonthlySkipsShortMonths(t *testing.T) {
+	dtstart := time.Date(2026, 1, 31, 9, 0, 0, 0, time.UTC)
+	rule, err := parseRRule("FREQ=MONTHLY;COUNT=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := formatOccurrences(rule.Between(dtstart, dtstart, dtstart.AddDate(1, 0, 0), nil))
+	if want := "2026-01-31 09:00,2026-03-31 09:00,2026-05-31 09:00"; got != want {
+		t.Errorf("Between() = %s, want %s", got, want)
+	}
+}
+
+func TestRRuleBetweenKeepsWallClockAcrossDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("没有时区数据:", err)
+	}
+	// 2026-03-08美国开始夏令时
+	dtstart := time.Date(2026, 3, 6, 9, 0, 0, 0, loc)
+	rule, err := parseRRule("FREQ=DAILY;UNTIL=20260309")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := formatOccurrences(rule.Between(dtstart, dtstart, dtstart.AddDate(0, 1, 0), nil))
+	if want := "2026-03-06 09:00,2026-03-07 09:00,2026-03-08 09:00,2026-03-09 09:00"; got != want {
+		t.Errorf("Between() = %s, want %s", got, want)
+	}
+}
+
+func TestCalendarDaysBetween(t *testing.T) {
+	utc8 := time.FixedZone("UTC+8", 8*3600)
+	tests := []struct {
+		from, to time.Time
+		want     int
+	}{
+		{time.Date(2026, 10, 1, 23, 0, 0, 0, utc8), time.Date(2026, 10, 2, 1, 0, 0, 0, utc8), 1},
+		{time.Date(2026, 10, 1, 8, 0, 0, 0, utc8), time.Date(2026, 10, 1, 20, 0, 0, 0, utc8), 0},
+		{time.Date(2026, 1, 30, 8, 0, 0, 0, utc8), time.Date(2026, 3, 2, 8, 0, 0, 0, utc8), 31},
+		// to按from的时区取日期: UTC 10-01 20:00 是东八区的10-02
+		{time.Date(2026, 10, 1, 8, 0, 0, 0, utc8), time.Date(2026, 10, 1, 20, 0, 0, 0, time.UTC), 1},
+		{time.Date(2026, 10, 5, 8, 0, 0, 0, utc8), time.Date(2026, 10, 1, 8, 0, 0, 0, utc8), -4},
+	}
+	for _, tt := range tests {
+		if got := calendarDaysBetween(tt.from, tt.to); got != tt.want {
+			t.Errorf("calendarDaysBetween(%v, %v) = %d, want %d", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestOccurrenceView(t *testing.T) {
+	series := &Task{
+		Title:          "练琴",
+		StartDate:      "2026-01-30T08:00:00+08:00",
+		DueDate:        "2026-01-30T20:30:00+08:00",
+		RRule:          "FREQ=DAILY",
+		IsCompleted:    true,
+		ApprovalStatus: ApprovalApproved,
+	}
+	start := time.Date(2026, 3, 2, 8, 0, 0, 0, time.FixedZone("", 8*3600))
+
+	view := occurrenceView(series, start, nil)
+	if view.OccurrenceDate != "2026-03-02" {
+		t.Errorf("OccurrenceDate = %s", view.OccurrenceDate)
+	}
+	if view.StartDate != "2026-03-02T08:00:00+08:00" || view.DueDate != "2026-03-02T20:30:00+08:00" {
+		t.Errorf("StartDate/DueDate = %s/%s", view.StartDate, view.DueDate)
+	}
+	if view.IsCompleted || view.ApprovalStatus != ApprovalNone {
+		t.Errorf("没有实例记录时应该是未完成: %+v", view)
+	}
+
+	view = occurrenceView(series, start, &Occurrence{IsCompleted: false, ApprovalStatus: ApprovalPending, CompletedAt: "2026-03-02 21:00:00"})
+	if view.ApprovalStatus != ApprovalPending || view.CompletionRequestedAt != "2026-03-02 21:00:00" {
+		t.Errorf("实例记录没有生效: %+v", view)
+	}
+	if series.StartDate != "2026-01-30T08:00:00+08:00" {
+		t.Error("occurrenceView不应修改系列任务")
+	}
+}
+
+// 客户端提交UTC时间，东八区周一早上7点在UTC是周日23点，应按任务的时区取星期和日期
+func TestExpandTaskUsesTaskTimeZone(t *testing.T) {
+	openTestDB(t)
+	tests := []struct {
+		name, timeZone, want string
+	}{
+		{"东八区", "+08:00", "2026-10-05 2026-10-04T23:00:00Z,2026-10-12 2026-10-11T23:00:00Z"},
+		{"没有时区时按UTC", "", "2026-10-05 2026-10-05T23:00:00Z,2026-10-12 2026-10-12T23:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := &Task{ID: "t1", UserID: "solo", Title: "晨读", StartDate: "2026-10-04T23:00:00Z",
+				RRule: "FREQ=WEEKLY;BYDAY=MO", TimeZone: tt.timeZone}
+			views, err := expandTask(task, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, view := range views {
+				got = append(got, view.OccurrenceDate+" "+view.StartDate)
+			}
+			if strings.Join(got, ",") != tt.want {
+				t.Errorf("expandTask() = %s, want %s", strings.Join(got, ","), tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateRecurrenceRejectsImpossibleRules(t *testing.T) {
+	tests := []struct {
+		name, rrule, timeZone string
+		valid                 bool
+	}{
+		{"每年二月30号", "FREQ=MONTHLY;INTERVAL=12;BYMONTHDAY=30", "", false},
+		{"每年二月倒数第30天", "FREQ=MONTHLY;INTERVAL=12;BYMONTHDAY=-30", "", false},
+		{"每年二月29号只在闰年", "FREQ=MONTHLY;INTERVAL=12;BYMONTHDAY=29", "", true},
+		{"隔月31号会落到大月", "FREQ=MONTHLY;INTERVAL=2;BYMONTHDAY=31", "", true},
+		{"多个日期中有一个可能", "FREQ=MONTHLY;INTERVAL=12;BYMONTHDAY=31,1", "", true},
+		{"未知时区", "FREQ=DAILY", "Mars/Base", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := &Task{StartDate: "2026-02-10T08:00:00Z", RRule: tt.rrule, TimeZone: tt.timeZone}
+			err := validateRecurrence(task)
+			if tt.valid && err != nil {
+				t.Errorf("validateRecurrence() = %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidRecurrence) {
+				t.Errorf("validateRecurrence() = %v, want ErrInvalidRecurrence", err)
+			}
+		})
+	}
+}
+
+// 周期开始不晚于其中的候选；已经保存的不可能规则在周期开始越过窗口后即停止展开
+func TestRRulePeriodStart(t *testing.T) {
+	dtstart := time.Date(2026, 2, 11, 8, 0, 0, 0, time.UTC) // 周三
+	tests := []struct {
+		rrule string
+		k     int
+		want  string
+	}{
+		{"FREQ=DAILY;INTERVAL=3", 2, "2026-02-17"},
+		{"FREQ=WEEKLY;BYDAY=MO,FR", 0, "2026-02-09"},
+		{"FREQ=WEEKLY;INTERVAL=2", 1, "2026-02-23"},
+		{"FREQ=MONTHLY;INTERVAL=12;BYMONTHDAY=30", 1, "2027-02-01"},
+	}
+	for _, tt := range tests {
+		rule, err := parseRRule(tt.rrule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rule.periodStart(dtstart, tt.k).Format(occurrenceDateLayout); got != tt.want {
+			t.Errorf("%s periodStart(%d) = %s, want %s", tt.rrule, tt.k, got, tt.want)
+		}
+	}
+
+	rule, _ := parseRRule("FREQ=MONTHLY;INTERVAL=12;BYMONTHDAY=30")
+	if got := rule.Between(dtstart, dtstart, dtstart.AddDate(0, 0, 400), nil); len(got) != 0 {
+		t.Errorf("Between() = %v, want 无实例", got)
+	}
+}
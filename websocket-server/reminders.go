@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 提醒的基准时间
+const (
+	ReminderAnchorDue   = "due"
+	ReminderAnchorStart = "start"
+)
+
+// ErrInvalidReminder 提醒偏移格式错误
+var ErrInvalidReminder = errors.New("无效的提醒设置")
+
+// ReminderOffset 在基准时间之前Minutes分钟提醒
+type ReminderOffset struct {
+	Anchor  string
+	Minutes int
+}
+
+// 解析提醒偏移，例如 "15,1440" 或 "start:0,due:30"。
+// 没有前缀时以截止时间为基准，任务没有截止时间时使用开始时间
+func parseReminderOffsets(value string) ([]ReminderOffset, error) {
+	offsets := []ReminderOffset{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		offset := ReminderOffset{}
+		if i := strings.Index(item, ":"); i >= 0 {
+			offset.Anchor = strings.ToLower(item[:i])
+			item = item[i+1:]
+			if offset.Anchor != ReminderAnchorDue && offset.Anchor != ReminderAnchorStart {
+				return nil, fmt.Errorf("%w: 未知的提醒基准 %s", ErrInvalidReminder, offset.Anchor)
+			}
+		}
+		minutes, err := strconv.Atoi(item)
+		if err != nil || minutes < 0 || minutes > 60*24*30 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidReminder, item)
+		}
+		offset.Minutes = minutes
+		offsets = append(offsets, offset)
+	}
+	return offsets, nil
+}
+
+func validateReminders(task *Task) error {
+	_, err := parseReminderOffsets(task.ReminderOffsets)
+	return err
+}
+
+// Reminder 一次待发送的提醒
+type Reminder struct {
+	Task          Task   `json:"task"`
+	Anchor        string `json:"anchor"`
+	AnchorTime    string `json:"anchor_time"`
+	OffsetMinutes int    `json:"offset_minutes"`
+	RemindAt      string `json:"remind_at"`
+}
+
+// 计算任务（或重复任务的某次实例）的提醒时间
+func taskReminders(task *Task, offsets []ReminderOffset) []Reminder {
+	reminders := []Reminder{}
+	for _, offset := range offsets {
+		anchor := offset.Anchor
+		if anchor == "" {
+			anchor = ReminderAnchorDue
+			if task.DueDate == "" {
+				anchor = ReminderAnchorStart
+			}
+		}
+		value := task.DueDate
+		if anchor == ReminderAnchorStart {
+			value = task.StartDate
+		}
+		at, ok := parseTaskTime(value)
+		if !ok {
+			continue
+		}
+		reminders = append(reminders, Reminder{
+			Task:          *task,
+			Anchor:        anchor,
+			AnchorTime:    at.UTC().Format(time.RFC3339),
+			OffsetMinutes: offset.Minutes,
+			RemindAt:      at.Add(-time.Duration(offset.Minutes) * time.Minute).UTC().Format(time.RFC3339),
+		})
+	}
+	return reminders
+}
+
+// ReminderScheduler 定时扫描任务的开始/截止时间，到点通过Hub发送reminder_due，
+// 并可选地调用外部webhook。已发送的提醒记录在reminders_sent表中，重启后不会重复发送；
+// 服务停机期间错过的提醒在lookback窗口内补发
+type ReminderScheduler struct {
+	store      TaskStore
+	interval   time.Duration
+	lookback   time.Duration
+	webhookURL string
+	client     *http.Client
+}
+
+func NewReminderScheduler(store TaskStore, interval, lookback time.Duration, webhookURL string) *ReminderScheduler {
+	return &ReminderScheduler{
+		store:      store,
+		interval:   interval,
+		lookback:   lookback,
+		webhookURL: webhookURL,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *ReminderScheduler) Run() {
+	log.Printf("⏰ 提醒调度器启动，扫描间隔 %v", s.interval)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.scan(time.Now().UTC())
+		<-ticker.C
+	}
+}
+
+// 扫描一次，发送remind_at落在(now-lookback, now]内且尚未发送的提醒
+func (s *ReminderScheduler) scan(now time.Time) {
+	tasks, err := s.store.ListWithReminders()
+	if err != nil {
+		log.Printf("❌ 扫描提醒失败: %v", err)
+		return
+	}
+
+	from := now.Add(-s.lookback)
+	due := []Reminder{}
+	for i := range tasks {
+		offsets, err := parseReminderOffsets(tasks[i].ReminderOffsets)
+		if err != nil || len(offsets) == 0 {
+			continue
+		}
+		for _, task := range s.instances(&tasks[i], offsets, from, now) {
+			if task.IsCompleted || task.ApprovalStatus == ApprovalPending {
+				continue
+			}
+			for _, reminder := range taskReminders(&task, offsets) {
+				remindAt, _ := time.Parse(time.RFC3339, reminder.RemindAt)
+				if remindAt.After(from) && !remindAt.After(now) {
+					due = append(due, reminder)
+				}
+			}
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool { return due[i].RemindAt < due[j].RemindAt })
+	for i := range due {
+		s.fire(&due[i])
+	}
+
+	// 清理早已超出补发窗口的发送记录
+	cutoff := now.Add(-s.lookback - 7*24*time.Hour).Format(timeLayout)
+	if _, err := db.Exec("DELETE FROM reminders_sent WHERE sent_at < ?", cutoff); err != nil {
+		log.Printf("⚠️ 清理提醒记录失败: %v", err)
+	}
+}
+
+// 需要检查提醒的任务实例：重复任务展开为提醒可能落在窗口内的实例
+func (s *ReminderScheduler) instances(task *Task, offsets []ReminderOffset, from, now time.Time) []Task {
+	if task.RRule == "" {
+		return []Task{*task}
+	}
+
+	maxOffset := 0
+	for _, offset := range offsets {
+		if offset.Minutes > maxOffset {
+			maxOffset = offset.Minutes
+		}
+	}
+	// 截止时间相对开始时间的偏移也要算进窗口
+	span := time.Duration(0)
+	start, okStart := parseTaskTime(task.StartDate)
+	due, okDue := parseTaskTime(task.DueDate)
+	if okStart && okDue && due.After(start) {
+		span = due.Sub(start)
+	}
+
+	views, err := expandTask(task, from.Add(-span), now.Add(time.Duration(maxOffset)*time.Minute).Add(time.Second))
+	if err != nil {
+		log.Printf("⚠️ 展开重复任务提醒失败: %s, %v", task.Title, err)
+		return nil
+	}
+	return views
+}
+
+// 先写入发送记录再发送，同一个提醒只会成功写入一次
+func (s *ReminderScheduler) fire(reminder *Reminder) {
+	taskID := getTaskIDString(&reminder.Task)
+	result, err := db.Exec(`INSERT OR IGNORE INTO reminders_sent (task_id, anchor, remind_at, offset_minutes, occurrence_date, sent_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		taskID, reminder.Anchor, reminder.RemindAt, reminder.OffsetMinutes, reminder.Task.OccurrenceDate,
+		time.Now().UTC().Format(timeLayout))
+	if err != nil {
+		log.Printf("❌ 记录提醒失败: %v", err)
+		return
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return
+	}
+
+	log.Printf("⏰ 发送提醒: %s (%s前%d分钟)", reminder.Task.Title, reminder.Anchor, reminder.OffsetMinutes)
+	sendBroadcast(reminder.Task.UserID, WSMessage{Type: "reminder_due", Data: reminder}, false)
+	if s.webhookURL != "" {
+		go s.postWebhook(*reminder)
+	}
+}
+
+// 调用外部webhook，失败时最多重试3次
+func (s *ReminderScheduler) postWebhook(reminder Reminder) {
+	body, err := json.Marshal(WSMessage{Type: "reminder_due", Data: reminder})
+	if err != nil {
+		log.Printf("❌ 序列化提醒失败: %v", err)
+		return
+	}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		resp, err := s.client.Post(s.webhookURL, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return
+			}
+			err = fmt.Errorf("HTTP %d", resp.StatusCode)
+		}
+		log.Printf("⚠️ 提醒webhook调用失败(第%d次): %v", attempt, err)
+		time.Sleep(time.Duration(attempt) * 5 * time.Second)
+	}
+}
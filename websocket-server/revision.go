@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrConflict 任务已被其他设备修改，基础版本不是最新
+var ErrConflict = errors.New("任务已被修改，请基于最新版本重试")
+
+// ConflictError 版本冲突，带上服务器当前的任务，客户端据此合并后重新提交。
+// Fields为两边都修改过的字段，为空时表示无法合并
+type ConflictError struct {
+	Current *Task
+	Fields  []string
+}
+
+func (e *ConflictError) Error() string { return ErrConflict.Error() }
+
+func (e *ConflictError) Is(target error) bool { return target == ErrConflict }
+
+// 任务的ETag就是版本号
+func setETag(w http.ResponseWriter, task *Task) {
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatInt(task.Revision, 10)))
+}
+
+// 解析If-Match头，支持 "3"、W/"3" 和 3；为空或*时返回0表示不检查版本
+func parseIfMatch(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "*" {
+		return 0, nil
+	}
+	value = strings.Trim(strings.TrimPrefix(value, "W/"), `"`)
+	revision, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || revision <= 0 {
+		return 0, errors.New("无效的If-Match")
+	}
+	return revision, nil
+}
+
+// 版本冲突的响应体带上服务器当前的任务和冲突的字段
+func writeConflict(w http.ResponseWriter, status int, conflict *ConflictError) {
+	setETag(w, conflict.Current)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   "conflict",
+		"message": ErrConflict.Error(),
+		"current": conflict.Current,
+		"fields":  conflict.Fields,
+	})
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"html"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// 索引内容的格式版本，修改分词或索引的字段后加一，启动时会重建索引
+const searchIndexVersion = 2
+
+// 搜索时从索引取出的候选任务上限
+const searchCandidateLimit = 1000
+
+// 摘要中匹配位置前后保留的字数
+const snippetRadius = 20
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// 中日韩文字没有空格分词，按单字和相邻两字(bigram)建索引
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// 把文本切分为连续的中日韩文字段和字母数字段，统一小写，其余字符作为分隔
+func searchRuns(text string) [][]rune {
+	var runs [][]rune
+	var current []rune
+	currentCJK := false
+	flush := func() {
+		if len(current) > 0 {
+			runs = append(runs, current)
+			current = nil
+		}
+	}
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			if !currentCJK {
+				flush()
+			}
+			currentCJK = true
+			current = append(current, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if currentCJK {
+				flush()
+			}
+			currentCJK = false
+			current = append(current, unicode.ToLower(r))
+		default:
+			flush()
+		}
+	}
+	flush()
+	return runs
+}
+
+// 建索引用的词: 英文/数字按单词，中文每个字和每两个相邻字都是一个词
+func searchTokens(text string) string {
+	var tokens []string
+	for _, run := range searchRuns(text) {
+		if !isCJK(run[0]) {
+			tokens = append(tokens, string(run))
+			continue
+		}
+		for i := range run {
+			tokens = append(tokens, string(run[i]))
+			if i+1 < len(run) {
+				tokens = append(tokens, string(run[i:i+2]))
+			}
+		}
+	}
+	return strings.Join(tokens, " ")
+}
+
+// 把搜索词转换为MATCH表达式，所有词都要出现: 中文单字直接匹配，多字拆为bigram，英文按前缀匹配
+func searchMatchExpression(runs [][]rune) string {
+	var terms []string
+	for _, run := range runs {
+		switch {
+		case !isCJK(run[0]):
+			terms = append(terms, string(run)+"*")
+		case len(run) == 1:
+			terms = append(terms, string(run))
+		default:
+			for i := 0; i+1 < len(run); i++ {
+				terms = append(terms, string(run[i:i+2]))
+			}
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+// 进度记录中的文字: progress_notes和daily_progress中所有的字符串值
+func taskProgressNotes(task *Task) string {
+	var notes []string
+	if task.ProgressNotes != "" {
+		notes = append(notes, task.ProgressNotes)
+	}
+	var progress interface{}
+	if err := json.Unmarshal([]byte(task.DailyProgress), &progress); err != nil {
+		return strings.Join(notes, "\n")
+	}
+	var collect func(value interface{})
+	collect = func(value interface{}) {
+		switch v := value.(type) {
+		case string:
+			notes = append(notes, v)
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				collect(v[key])
+			}
+		case []interface{}:
+			for _, item := range v {
+				collect(item)
+			}
+		}
+	}
+	collect(progress)
+	return strings.Join(notes, "\n")
+}
+
+// 全文索引表由迁移创建。只有索引版本不一致、或者索引与任务对不上时
+// （例如旧版本程序写入过任务）才重建，正常启动不重建
+func ensureSearchIndex(db *sql.DB) error {
+	var version int
+	err := db.QueryRow("SELECT version FROM task_search_state WHERE id = 1").Scan(&version)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if version == searchIndexVersion {
+		var missing, stale int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM tasks WHERE ` + notDeleted + `
+			AND id NOT IN (SELECT task_id FROM task_search_ids)`).Scan(&missing); err != nil {
+			return err
+		}
+		if err := db.QueryRow(`SELECT COUNT(*) FROM task_search_ids
+			WHERE task_id NOT IN (SELECT id FROM tasks WHERE ` + notDeleted + `)`).Scan(&stale); err != nil {
+			return err
+		}
+		// 每个有编号的任务在索引中恰好有一行
+		var ids, rows int
+		if err := db.QueryRow("SELECT (SELECT COUNT(*) FROM task_search_ids), (SELECT COUNT(*) FROM task_search)").Scan(&ids, &rows); err != nil {
+			return err
+		}
+		stale += rows - ids
+		if missing == 0 && stale == 0 {
+			return nil
+		}
+		log.Printf("⚠️ 全文索引与任务不一致: 缺少%d条, 多余%d条", missing, stale)
+	}
+	return rebuildSearchIndex(db)
+}
+
+// 清空并重建全文索引
+func rebuildSearchIndex(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM task_search"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM task_search_ids"); err != nil {
+		return err
+	}
+	tasks, err := NewSQLiteTaskStore(db).queryList(notDeleted)
+	if err != nil {
+		return err
+	}
+	for i := range tasks {
+		if err := indexTask(tx, &tasks[i]); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO task_search_state (id, version, rebuilt_at) VALUES (1, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET version = excluded.version, rebuilt_at = excluded.rebuilt_at`,
+		searchIndexVersion, time.Now().UTC().Format(timeLayout)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("🔎 全文索引已重建: %d 个任务", len(tasks))
+	return nil
+}
+
+// 写入或更新任务的索引，索引行的docid是task_search_ids中为该任务分配的编号
+func indexTask(tx execer, task *Task) error {
+	id := getTaskIDString(task)
+	if err := unindexTask(tx, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO task_search_ids (task_id) VALUES (?)", id); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`INSERT INTO task_search (docid, title, description, notes)
+		SELECT docid, ?, ?, ? FROM task_search_ids WHERE task_id = ?`,
+		searchTokens(task.Title), searchTokens(task.Description), searchTokens(taskProgressNotes(task)), id)
+	return err
+}
+
+// 先按docid删除索引行，再删除编号
+func unindexTask(tx execer, id string) error {
+	if _, err := tx.Exec("DELETE FROM task_search WHERE docid = (SELECT docid FROM task_search_ids WHERE task_id = ?)", id); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM task_search_ids WHERE task_id = ?", id)
+	return err
+}
+
+// SearchCandidates 全文索引中匹配的未删除任务，需要调用方进一步过滤
+func (s *SQLiteTaskStore) SearchCandidates(userIDs []string, runs [][]rune) ([]Task, error) {
+	placeholders := "?" + strings.Repeat(", ?", len(userIDs)-1)
+	args := make([]interface{}, 0, len(userIDs)+2)
+	for _, userID := range userIDs {
+		args = append(args, userID)
+	}
+	where := notDeleted + " AND user_id IN (" + placeholders + ")" +
+		" AND id IN (SELECT m.task_id FROM task_search s JOIN task_search_ids m ON m.docid = s.docid WHERE task_search MATCH ?)"
+	args = append(args, searchMatchExpression(runs))
+	args = append(args, searchCandidateLimit)
+	return s.queryList(where+" ORDER BY updated_at DESC LIMIT ?", args...)
+}
+
+// SearchResult 搜索结果，高亮的片段是HTML，匹配的词用<mark>标出
+type SearchResult struct {
+	Task           Task     `json:"task"`
+	Score          float64  `json:"score"`
+	TitleHighlight string   `json:"title_highlight"`
+	Snippet        string   `json:"snippet"`
+	MatchedFields  []string `json:"matched_fields"`
+}
+
+// SearchResponse GET /api/tasks/search 的返回
+type SearchResponse struct {
+	Query   string         `json:"query"`
+	Results []SearchResult `json:"results"`
+	Total   int            `json:"total"`
+	Limit   int            `json:"limit"`
+	Offset  int            `json:"offset"`
+}
+
+// 忽略大小写查找needle在text中的所有位置（按rune计）
+func findRuns(text, needle []rune) []int {
+	var positions []int
+	for i := 0; i+len(needle) <= len(text); i++ {
+		match := true
+		for j, r := range needle {
+			if unicode.ToLower(text[i+j]) != r {
+				match = false
+				break
+			}
+		}
+		if match {
+			positions = append(positions, i)
+		}
+	}
+	return positions
+}
+
+// 标记text中所有匹配的区间
+func matchedMask(text []rune, runs [][]rune) ([]bool, int) {
+	mask := make([]bool, len(text))
+	count := 0
+	for _, run := range runs {
+		for _, position := range findRuns(text, run) {
+			count++
+			for i := position; i < position+len(run); i++ {
+				mask[i] = true
+			}
+		}
+	}
+	return mask, count
+}
+
+// 转义HTML并用<mark>标出[from, to)中匹配的部分
+func highlightRange(text []rune, mask []bool, from, to int) string {
+	var b strings.Builder
+	marked := false
+	for i := from; i < to; i++ {
+		if mask[i] != marked {
+			if mask[i] {
+				b.WriteString("<mark>")
+			} else {
+				b.WriteString("</mark>")
+			}
+			marked = mask[i]
+		}
+		b.WriteString(html.EscapeString(string(text[i])))
+	}
+	if marked {
+		b.WriteString("</mark>")
+	}
+	return b.String()
+}
+
+// 第一个匹配位置附近的摘要
+func searchSnippet(text []rune, mask []bool) string {
+	first := 0
+	for i, matched := range mask {
+		if matched {
+			first = i
+			break
+		}
+	}
+	from, to := first-snippetRadius, first+snippetRadius*2
+	if from < 0 {
+		from = 0
+	}
+	if to > len(text) {
+		to = len(text)
+	}
+	snippet := highlightRange(text, mask, from, to)
+	if from > 0 {
+		snippet = "…" + snippet
+	}
+	if to < len(text) {
+		snippet += "…"
+	}
+	return strings.ReplaceAll(snippet, "\n", " ")
+}
+
+// 计算任务的得分和高亮。每个搜索词都必须出现在某个字段中，否则返回false。
+// 标题中的匹配权重最高，整句出现在标题中额外加分
+func scoreSearchResult(task *Task, query string, runs [][]rune) (SearchResult, bool) {
+	fields := []struct {
+		name   string
+		text   []rune
+		weight float64
+	}{
+		{"title", []rune(task.Title), 3},
+		{"description", []rune(task.Description), 1},
+		{"notes", []rune(taskProgressNotes(task)), 0.5},
+	}
+
+	result := SearchResult{Task: *task, MatchedFields: []string{}}
+	for _, run := range runs {
+		found := false
+		for _, field := range fields {
+			if len(findRuns(field.text, run)) > 0 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return result, false
+		}
+	}
+
+	for _, field := range fields {
+		mask, count := matchedMask(field.text, runs)
+		if field.name == "title" {
+			result.TitleHighlight = highlightRange(field.text, mask, 0, len(field.text))
+		}
+		if count == 0 {
+			continue
+		}
+		result.MatchedFields = append(result.MatchedFields, field.name)
+		result.Score += field.weight * float64(count) / (1 + float64(len(field.text))/100)
+		if field.name != "title" && result.Snippet == "" {
+			result.Snippet = searchSnippet(field.text, mask)
+		}
+	}
+	if result.Snippet == "" {
+		// 只有标题匹配时用描述的开头作为摘要
+		description := fields[1].text
+		result.Snippet = searchSnippet(description, make([]bool, len(description)))
+	}
+	if strings.Contains(strings.ToLower(task.Title), strings.ToLower(strings.TrimSpace(query))) {
+		result.Score += 5
+	}
+	return result, true
+}
+
+// 搜索REST处理器: GET /api/tasks/search?q=...&user_id=&limit=&offset=，
+// 默认搜索自己能看到的所有任务，指定user_id时只搜索该成员的任务
+func searchTasksHandler(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	runs := searchRuns(query)
+	if len(runs) == 0 {
+		http.Error(w, "缺少搜索关键词", http.StatusBadRequest)
+		return
+	}
+
+	limit, offset := 20, 0
+	var err error
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > 100 {
+			http.Error(w, "limit必须在1到100之间", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := r.URL.Query().Get("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			http.Error(w, "无效的offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	user := userFromContext(r.Context())
+	var userIDs []string
+	if ownerID := r.URL.Query().Get("user_id"); ownerID != "" {
+		if err := authorizeTaskList(user.ID, ownerID); err != nil {
+			writeTaskError(w, err)
+			return
+		}
+		userIDs = []string{ownerID}
+	} else {
+		membership, err := membershipOf(user.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if membership == nil {
+			membership = &Membership{}
+		}
+		userIDs = visibleUserIDs(user.ID, membership.FamilyID, membership.Role)
+	}
+
+	candidates, err := taskService.store.SearchCandidates(userIDs, runs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	results := []SearchResult{}
+	for i := range candidates {
+		if result, ok := scoreSearchResult(&candidates[i], query, runs); ok {
+			results = append(results, result)
+		}
+	}
+	// 候选已按更新时间倒序，得分相同时保持这个顺序
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+
+	response := SearchResponse{Query: query, Total: len(results), Limit: limit, Offset: offset}
+	if offset > len(results) {
+		offset = len(results)
+	}
+	if end := offset + limit; end < len(results) {
+		results = results[:end]
+	}
+	response.Results = results[offset:]
+
+	log.Printf("🔎 搜索任务: q=%s, 结果数=%d", query, response.Total)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSearchTokens(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"Hello World", "hello world"},
+		{"数学", "数 数学 学"},
+		{"数学作业", "数 数学 学 学作 作 作业 业"},
+		{"做Math题", "做 math 题"},
+		{"期中考试，语文", "期 期中 中 中考 考 考试 试 语 语文 文"},
+		{"page 12-13", "page 12 13"},
+		{"ひらがな", "ひ ひら ら らが が がな な"},
+		{"", ""},
+		{"！？", ""},
+	}
+	for _, tt := range tests {
+		if got := searchTokens(tt.text); got != tt.want {
+			t.Errorf("searchTokens(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSearchMatchExpression(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{"数", "数"},
+		{"数学", "数学"},
+		{"数学作业", "数学 学作 作业"},
+		{"Eng", "eng*"},
+		{"数学 page", "数学 page*"},
+	}
+	for _, tt := range tests {
+		if got := searchMatchExpression(searchRuns(tt.query)); got != tt.want {
+			t.Errorf("searchMatchExpression(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestTaskProgressNotes(t *testing.T) {
+	task := &Task{
+		ProgressNotes: "完成了一半",
+		DailyProgress: `{"2026-10-02":{"progress":50,"note":"第二天"},"2026-10-01":{"note":"第一天"}}`,
+	}
+	if got, want := taskProgressNotes(task), "完成了一半\n第一天\n第二天"; got != want {
+		t.Errorf("taskProgressNotes() = %q, want %q", got, want)
+	}
+	if got := taskProgressNotes(&Task{DailyProgress: "not json"}); got != "" {
+		t.Errorf("taskProgressNotes() = %q", got)
+	}
+}
+
+func TestScoreSearchResult(t *testing.T) {
+	task := &Task{Title: "数学作业", Description: "完成练习册第12页 <b>", ProgressNotes: "还剩两道题"}
+
+	if _, ok := scoreSearchResult(task, "数学 语文", searchRuns("数学 语文")); ok {
+		t.Error("每个搜索词都必须出现")
+	}
+
+	result, ok := scoreSearchResult(task, "数学", searchRuns("数学"))
+	if !ok {
+		t.Fatal("应该匹配标题")
+	}
+	if result.TitleHighlight != "<mark>数学</mark>作业" {
+		t.Errorf("TitleHighlight = %s", result.TitleHighlight)
+	}
+	if !reflect.DeepEqual(result.MatchedFields, []string{"title"}) {
+		t.Errorf("MatchedFields = %v", result.MatchedFields)
+	}
+	// 只有标题匹配时摘要是描述的开头，HTML需要转义
+	if result.Snippet != "完成练习册第12页 &lt;b&gt;" {
+		t.Errorf("Snippet = %s", result.Snippet)
+	}
+
+	inNotes, ok := scoreSearchResult(task, "两道", searchRuns("两道"))
+	if !ok || inNotes.Snippet != "还剩<mark>两道</mark>题" {
+		t.Errorf("进度记录中的匹配: %+v", inNotes)
+	}
+	if inNotes.Score >= result.Score {
+		t.Errorf("标题中的匹配得分应该更高: %v >= %v", inNotes.Score, result.Score)
+	}
+}
+
+func TestSearchCandidates(t *testing.T) {
+	openTestDB(t)
+	if err := ensureSearchIndex(db); err != nil {
+		t.Fatal(err)
+	}
+	math := createTestTask(t, "solo", Task{Title: "数学作业", Description: "练习册"})
+	createTestTask(t, "solo", Task{Title: "English reading", Description: "chapter 3"})
+	createTestTask(t, "other", Task{Title: "数学竞赛"})
+
+	search := func(query string) string {
+		t.Helper()
+		tasks, err := taskService.store.SearchCandidates([]string{"solo"}, searchRuns(query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var titles []string
+		for i := range tasks {
+			titles = append(titles, tasks[i].Title)
+		}
+		return strings.Join(titles, ",")
+	}
+
+	tests := []struct {
+		query, want string
+	}{
+		{"数学", "数学作业"},
+		{"作", "数学作业"},
+		{"学作", "数学作业"},
+		{"engl", "English reading"},
+		{"CHAPTER", "English reading"},
+		{"语文", ""},
+		// 任务ID不在索引内容中，不会匹配到每个任务
+		{"task", ""},
+	}
+	for _, tt := range tests {
+		if got := search(tt.query); got != tt.want {
+			t.Errorf("搜索%q = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	// 修改后索引随之更新，删除后不再出现
+	math.Title = "语文作业"
+	if err := taskService.UpdateTask("solo", math); err != nil {
+		t.Fatal(err)
+	}
+	if got := search("语文"); got != "语文作业" {
+		t.Errorf("修改后搜索 = %q", got)
+	}
+	if err := taskService.DeleteTask("solo", math); err != nil {
+		t.Fatal(err)
+	}
+	if got := search("语文"); got != "" {
+		t.Errorf("删除后搜索 = %q", got)
+	}
+}
+
+func TestSearchIndexKeyedByDocid(t *testing.T) {
+	openTestDB(t)
+	task := createTestTask(t, "solo", Task{Title: "数学作业"})
+	createTestTask(t, "solo", Task{Title: "语文作业"})
+
+	rows := func() (ids, indexed int) {
+		t.Helper()
+		if err := db.QueryRow("SELECT (SELECT COUNT(*) FROM task_search_ids), (SELECT COUNT(*) FROM task_search)").Scan(&ids, &indexed); err != nil {
+			t.Fatal(err)
+		}
+		return ids, indexed
+	}
+
+	// 多次修改后每个任务仍然只有一行索引
+	for _, title := range []string{"数学练习", "数学复习"} {
+		task.Title = title
+		if err := taskService.UpdateTask("solo", task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ids, indexed := rows(); ids != 2 || indexed != 2 {
+		t.Errorf("修改后编号%d个, 索引%d行, 期望都是2", ids, indexed)
+	}
+	if err := taskService.DeleteTask("solo", task); err != nil {
+		t.Fatal(err)
+	}
+	if ids, indexed := rows(); ids != 1 || indexed != 1 {
+		t.Errorf("删除后编号%d个, 索引%d行, 期望都是1", ids, indexed)
+	}
+
+	// 删除索引行按docid查找，不扫描整个索引表（FTS4中idxNum=1表示按docid查找）
+	var id, parent, unused int
+	var detail string
+	if err := db.QueryRow("EXPLAIN QUERY PLAN DELETE FROM task_search WHERE docid = (SELECT docid FROM task_search_ids WHERE task_id = ?)", "x").
+		Scan(&id, &parent, &unused, &detail); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(detail, "VIRTUAL TABLE INDEX 1:") {
+		t.Errorf("查询计划 = %q, 应按docid查找", detail)
+	}
+}
+
+func TestEnsureSearchIndexRebuildsOnlyWhenStale(t *testing.T) {
+	openTestDB(t)
+	createTestTask(t, "solo", Task{Title: "数学作业"})
+
+	count := func() int {
+		t.Helper()
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM task_search").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	rebuiltAt := func() string {
+		t.Helper()
+		var value string
+		db.QueryRow("SELECT COALESCE(rebuilt_at, '') FROM task_search_state WHERE id = 1").Scan(&value)
+		return value
+	}
+
+	// 迁移后还没有记录索引版本，第一次启动时重建
+	if err := ensureSearchIndex(db); err != nil {
+		t.Fatal(err)
+	}
+	first := rebuiltAt()
+	if first == "" || count() != 1 {
+		t.Fatalf("没有重建: rebuilt_at=%q, rows=%d", first, count())
+	}
+
+	db.Exec("UPDATE task_search_state SET rebuilt_at = 'marker'")
+	if err := ensureSearchIndex(db); err != nil {
+		t.Fatal(err)
+	}
+	if rebuiltAt() != "marker" {
+		t.Error("索引是最新的时候不应该重建")
+	}
+
+	// 旧版本程序写入的任务没有索引
+	db.Exec("INSERT INTO tasks (id, user_id, title) VALUES ('legacy', 'solo', '语文')")
+	if err := ensureSearchIndex(db); err != nil {
+		t.Fatal(err)
+	}
+	if rebuiltAt() == "marker" || count() != 2 {
+		t.Errorf("缺少索引时应该重建: rows=%d", count())
+	}
+
+	db.Exec("UPDATE task_search_state SET version = 0, rebuilt_at = 'marker'")
+	if err := ensureSearchIndex(db); err != nil {
+		t.Fatal(err)
+	}
+	if rebuiltAt() == "marker" {
+		t.Error("索引版本不一致时应该重建")
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// TaskService 任务业务层：REST路由和WebSocket消息都通过它读写任务、校验家庭角色权限并广播变更
+type TaskService struct {
+	store TaskStore
+}
+
+func NewTaskService(store TaskStore) *TaskService {
+	return &TaskService{store: store}
+}
+
+// GetTaskForUser 获取用户有权访问的任务，无权访问时按不存在处理
+func (s *TaskService) GetTaskForUser(actorID, id string) (*Task, error) {
+	task, err := s.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeTaskView(actorID, task); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// ListTasks 列出ownerID名下的任务，只有本人或家庭中的家长可以查看
+func (s *TaskService) ListTasks(actorID, ownerID string) ([]Task, error) {
+	if err := authorizeTaskList(actorID, ownerID); err != nil {
+		return nil, err
+	}
+	return s.store.List(ownerID)
+}
+
+// QueryTasks 按条件分页查询q.OwnerIDs的任务，返回当前页和总数
+func (s *TaskService) QueryTasks(actorID string, q TaskQuery) ([]Task, int, error) {
+	if len(q.OwnerIDs) == 0 {
+		return nil, 0, ErrForbidden
+	}
+	for _, ownerID := range q.OwnerIDs {
+		if err := authorizeTaskList(actorID, ownerID); err != nil {
+			return nil, 0, err
+		}
+	}
+	if err := q.compileFilter(actorID, time.Now()); err != nil {
+		return nil, 0, err
+	}
+	return s.store.Query(q)
+}
+
+// FindTask 在用户可见的任务中查找，优先使用record_id，没有record_id时使用title+device_id
+func (s *TaskService) FindTask(userIDs []string, recordID, title, deviceID string) (*Task, error) {
+	if recordID != "" {
+		log.Printf("🔍 使用record_id查找任务: %s", recordID)
+	} else {
+		log.Printf("🔍 使用title+device_id查找任务")
+	}
+
+	for _, userID := range userIDs {
+		var task *Task
+		var err error
+		if recordID != "" {
+			task, err = s.store.FindByRecordID(recordID, userID)
+		} else {
+			task, err = s.store.FindDuplicate(title, deviceID, userID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if task != nil {
+			return task, nil
+		}
+	}
+	return nil, ErrTaskNotFound
+}
+
+func (s *TaskService) FindDuplicate(title, deviceID, userID string) (*Task, error) {
+	return s.store.FindDuplicate(title, deviceID, userID)
+}
+
+func (s *TaskService) CreateTask(actorID string, task *Task) error {
+	if task.UserID == "" {
+		task.UserID = actorID
+	}
+	if err := authorizeTaskCreate(actorID, task.UserID); err != nil {
+		return err
+	}
+	task.CreatedBy = actorID
+	keepApprovalFields(&Task{}, task)
+	if err := validateRecurrence(task); err != nil {
+		return err
+	}
+	if err := validateReminders(task); err != nil {
+		return err
+	}
+	if err := validateWorkProgress(task); err != nil {
+		return err
+	}
+
+	if err := s.store.Create(task); err != nil {
+		return err
+	}
+	log.Printf("✅ 任务创建成功: %s", task.Title)
+
+	// 广播任务创建事件
+	broadcastTaskChange("task_created", task)
+	settleTaskPoints(actorID, task, false)
+	return nil
+}
+
+func (s *TaskService) UpdateTask(actorID string, task *Task) error {
+	return s.updateTask(actorID, task, false)
+}
+
+// UpdateTaskIfMatch 带If-Match的更新：版本号必须是最新的，否则直接返回冲突，不做合并
+func (s *TaskService) UpdateTaskIfMatch(actorID string, task *Task) error {
+	return s.updateTask(actorID, task, true)
+}
+
+func (s *TaskService) updateTask(actorID string, task *Task, strict bool) error {
+	current, err := s.store.Get(getTaskIDString(task))
+	if err != nil {
+		return err
+	}
+	// 没有带版本号的旧客户端直接覆盖最新版本，基于旧版本的修改尝试按字段合并
+	if task.Revision == 0 {
+		task.Revision = current.Revision
+	} else if strict && task.Revision != current.Revision {
+		return &ConflictError{Current: current}
+	} else if task.Revision != current.Revision {
+		if err := s.mergeConcurrentEdit(current, task); err != nil {
+			return err
+		}
+	}
+	keepApprovalFields(current, task)
+	if err := authorizeTaskUpdate(actorID, current, task); err != nil {
+		return err
+	}
+	task.CreatedBy = current.CreatedBy
+	if err := validateRecurrence(task); err != nil {
+		return err
+	}
+	if err := validateReminders(task); err != nil {
+		return err
+	}
+	if err := validateWorkProgress(task); err != nil {
+		return err
+	}
+
+	completionRequested, err := applyCompletionWorkflow(actorID, current, task)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.Update(task); err != nil {
+		return err
+	}
+	log.Printf("✅ 任务更新成功: %s", task.Title)
+
+	// 重新分配给其他成员时，原所有者的设备需要移除该任务
+	if current.UserID != task.UserID {
+		broadcastTaskChange("task_deleted", current)
+		broadcastTaskChange("task_created", task)
+	} else {
+		// 广播任务更新事件
+		broadcastTaskChange("task_updated", task)
+	}
+	if completionRequested {
+		log.Printf("🙋 孩子提交了完成申请: %s", task.Title)
+		broadcastToParents("completion_requested", task)
+	}
+	settleTaskPoints(actorID, task, current.IsCompleted)
+	return nil
+}
+
+func (s *TaskService) DeleteTask(actorID string, task *Task) error {
+	if err := authorizeTaskDelete(actorID, task); err != nil {
+		return err
+	}
+	if err := s.store.Delete(getTaskIDString(task), actorID); err != nil {
+		return err
+	}
+	log.Printf("🗑️ 任务删除成功: %s", task.Title)
+
+	// 广播任务删除事件
+	broadcastTaskChange("task_deleted", task)
+	return nil
+}
+
+// ListTrash 列出ownerID回收站中的任务，权限与ListTasks相同
+func (s *TaskService) ListTrash(actorID, ownerID string) ([]Task, error) {
+	if actorID != ownerID {
+		ok, err := isParentOf(actorID, ownerID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrForbidden
+		}
+	}
+	return s.store.ListDeleted(ownerID)
+}
+
+// RestoreTask 从回收站恢复任务，需要有删除该任务的权限
+func (s *TaskService) RestoreTask(actorID, id string) (*Task, error) {
+	task, err := s.store.GetDeleted(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeTaskDelete(actorID, task); err != nil {
+		return nil, err
+	}
+	if err := s.store.Restore(id); err != nil {
+		return nil, err
+	}
+	if task, err = s.store.Get(id); err != nil {
+		return nil, err
+	}
+	log.Printf("♻️ 任务已恢复: %s", task.Title)
+
+	broadcastTaskChange("task_restored", task)
+	return task, nil
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrTaskNotFound 任务不存在
+var ErrTaskNotFound = errors.New("未找到任务")
+
+// 数据库时间格式，与SQLite的CURRENT_TIMESTAMP保持一致
+const timeLayout = "2006-01-02 15:04:05"
+
+// TaskStore 任务持久化接口，REST和WebSocket共用
+type TaskStore interface {
+	Create(task *Task) error
+	Get(id string) (*Task, error)
+	List(userID string) ([]Task, error)
+	Query(q TaskQuery) ([]Task, int, error)
+	Update(task *Task) error
+	Delete(id, deletedBy string) error
+	FindDuplicate(title, deviceID, userID string) (*Task, error)
+	FindByRecordID(recordID, userID string) (*Task, error)
+	ListWithReminders() ([]Task, error)
+	GetDeleted(id string) (*Task, error)
+	ListDeleted(userID string) ([]Task, error)
+	Restore(id string) error
+	PurgeDeleted(before time.Time) (int64, error)
+	GetRevision(id string, revision int64) (*Task, error)
+	SearchCandidates(userIDs []string, runs [][]rune) ([]Task, error)
+	ListDailyProgress(taskID string) ([]DailyProgress, error)
+	SaveDailyProgress(p *DailyProgress) (*Task, error)
+}
+
+// SQLiteTaskStore 基于本地tasks.db的TaskStore实现
+type SQLiteTaskStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteTaskStore(db *sql.DB) *SQLiteTaskStore {
+	return &SQLiteTaskStore{db: db}
+}
+
+// 查询任务时统一使用的字段列表，顺序需与scanTask一致
+const taskColumns = `id, COALESCE(user_id, 'default_user'), title,
+	COALESCE(description, ''), COALESCE(start_date, ''), COALESCE(due_date, ''),
+	COALESCE(is_completed, 0), COALESCE(category, '学习'), COALESCE(priority, 1),
+	COALESCE(device_id, ''), COALESCE(record_id, ''),
+	COALESCE(created_at, ''), COALESCE(updated_at, ''),
+	COALESCE(daily_progress, '{}'), COALESCE(created_by, ''),
+	COALESCE(approval_status, ''), COALESCE(approval_comment, ''), COALESCE(completion_requested_at, ''),
+	COALESCE(reviewed_by, ''), COALESCE(reviewed_at, ''), COALESCE(points, 0),
+	COALESCE(rrule, ''), COALESCE(exdates, ''), COALESCE(reminder_offsets, ''),
+	COALESCE(deleted_at, ''), COALESCE(deleted_by, ''), COALESCE(revision, 1),
+	COALESCE(field_revisions, '{}'), COALESCE(work_progress, 0), COALESCE(time_spent, 0),
+	COALESCE(progress_notes, ''), COALESCE(time_zone, '')`
+
+// 未删除的任务，软删除的任务只出现在回收站
+const notDeleted = "COALESCE(deleted_at, '') = ''"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTask(row rowScanner) (*Task, error) {
+	var task Task
+	var id, fieldRevisions string
+	err := row.Scan(
+		&id, &task.UserID, &task.Title, &task.Description,
+		&task.StartDate, &task.DueDate, &task.IsCompleted, &task.Category, &task.Priority,
+		&task.DeviceID, &task.RecordID, &task.CreatedAt, &task.UpdatedAt, &task.DailyProgress,
+		&task.CreatedBy,
+		&task.ApprovalStatus, &task.ApprovalComment, &task.CompletionRequestedAt,
+		&task.ReviewedBy, &task.ReviewedAt, &task.Points,
+		&task.RRule, &task.ExDates, &task.ReminderOffsets,
+		&task.DeletedAt, &task.DeletedBy, &task.Revision, &fieldRevisions,
+		&task.WorkProgress, &task.TimeSpent, &task.ProgressNotes, &task.TimeZone,
+	)
+	if err != nil {
+		return nil, err
+	}
+	task.ID = id
+	if err := json.Unmarshal([]byte(fieldRevisions), &task.FieldRevisions); err != nil {
+		task.FieldRevisions = nil
+	}
+	return &task, nil
+}
+
+func (s *SQLiteTaskStore) queryOne(where string, args ...interface{}) (*Task, error) {
+	task, err := scanTask(s.db.QueryRow("SELECT "+taskColumns+" FROM tasks WHERE "+where, args...))
+	if err == sql.ErrNoRows {
+		return nil, ErrTaskNotFound
+	}
+	return task, err
+}
+
+func (s *SQLiteTaskStore) Create(task *Task) error {
+	// 生成ID如果没有
+	if getTaskIDString(task) == "" {
+		task.ID = fmt.Sprintf("task_%d", time.Now().UnixNano())
+	}
+	if task.UserID == "" {
+		task.UserID = "default_user"
+	}
+	if task.DailyProgress == "" {
+		task.DailyProgress = "{}"
+	}
+	if task.CreatedBy == "" {
+		task.CreatedBy = task.UserID
+	}
+	now := time.Now().UTC().Format(timeLayout)
+	task.CreatedAt = now
+	task.UpdatedAt = now
+	task.Revision = 1
+	task.FieldRevisions = map[string]int64{allFields: 1}
+	fieldRevisions, _ := json.Marshal(task.FieldRevisions)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if task.DailyProgress, err = importDailyProgress(tx, getTaskIDString(task), task.DailyProgress, now); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO tasks (id, user_id, title, description, start_date, due_date, is_completed,
+	          category, priority, device_id, record_id, created_at, updated_at, daily_progress, created_by, points,
+	          rrule, exdates, reminder_offsets, revision, field_revisions, work_progress, time_spent, progress_notes, time_zone)
+	          VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err = tx.Exec(query,
+		getTaskIDString(task), task.UserID, task.Title, task.Description,
+		task.StartDate, task.DueDate, task.IsCompleted, task.Category, task.Priority,
+		task.DeviceID, task.RecordID, task.CreatedAt, task.UpdatedAt, task.DailyProgress, task.CreatedBy,
+		task.Points, task.RRule, task.ExDates, task.ReminderOffsets, task.Revision, string(fieldRevisions),
+		task.WorkProgress, task.TimeSpent, task.ProgressNotes, task.TimeZone)
+	if err != nil {
+		log.Printf("❌ 创建任务失败: %v", err)
+		return err
+	}
+	if err := saveTaskHistory(tx, task); err != nil {
+		return err
+	}
+	if err := indexTask(tx, task); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteTaskStore) Get(id string) (*Task, error) {
+	return s.queryOne(notDeleted+" AND id = ?", id)
+}
+
+func (s *SQLiteTaskStore) List(userID string) ([]Task, error) {
+	return s.queryList(notDeleted+" AND user_id = ? ORDER BY created_at DESC", userID)
+}
+
+// ListWithReminders 所有设置了提醒的任务（不含已完成的普通任务），供提醒调度器扫描
+func (s *SQLiteTaskStore) ListWithReminders() ([]Task, error) {
+	return s.queryList(notDeleted + " AND COALESCE(reminder_offsets, '') != '' AND (COALESCE(rrule, '') != '' OR COALESCE(is_completed, 0) = 0)")
+}
+
+// GetDeleted 获取回收站中的任务
+func (s *SQLiteTaskStore) GetDeleted(id string) (*Task, error) {
+	return s.queryOne("COALESCE(deleted_at, '') != '' AND id = ?", id)
+}
+
+// ListDeleted 回收站，最近删除的在前
+func (s *SQLiteTaskStore) ListDeleted(userID string) ([]Task, error) {
+	return s.queryList("COALESCE(deleted_at, '') != '' AND user_id = ? ORDER BY deleted_at DESC", userID)
+}
+
+func (s *SQLiteTaskStore) queryList(where string, args ...interface{}) ([]Task, error) {
+	rows, err := s.db.Query("SELECT "+taskColumns+" FROM tasks WHERE "+where, args...)
+	if err != nil {
+		log.Printf("❌ 数据库查询失败: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := []Task{}
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			log.Printf("❌ 扫描任务数据失败: %v", err)
+			continue
+		}
+		tasks = append(tasks, *task)
+	}
+	return tasks, rows.Err()
+}
+
+// Update 保存任务，task.Revision必须是数据库中的当前版本，成功后加1；
+// 版本不一致时返回带当前任务的ConflictError。同时记录变化字段的版本和历史快照
+func (s *SQLiteTaskStore) Update(task *Task) error {
+	current, err := s.Get(getTaskIDString(task))
+	if err != nil {
+		return err
+	}
+	if current.Revision != task.Revision {
+		return &ConflictError{Current: current}
+	}
+	updatedAt := time.Now().UTC().Format(timeLayout)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 旧客户端整体提交的daily_progress先合并到进度表，再按进度表重新生成；与已保存的内容相同时跳过
+	if task.DailyProgress != current.DailyProgress {
+		if task.DailyProgress, err = importDailyProgress(tx, getTaskIDString(task), task.DailyProgress, updatedAt); err != nil {
+			return err
+		}
+	}
+	revision := task.Revision + 1
+	fieldRevisions := changedFieldRevisions(current, task, revision)
+	fieldRevisionsJSON, _ := json.Marshal(fieldRevisions)
+
+	query := `UPDATE tasks SET user_id=?, title=?, description=?, start_date=?, due_date=?, is_completed=?,
+	          category=?, priority=?, device_id=?, record_id=?, updated_at=?, daily_progress=?,
+	          approval_status=?, approval_comment=?, completion_requested_at=?, reviewed_by=?, reviewed_at=?,
+	          points=?, rrule=?, exdates=?, reminder_offsets=?, revision=?, field_revisions=?,
+	          work_progress=?, time_spent=?, progress_notes=?, time_zone=?
+	          WHERE id=? AND COALESCE(revision, 1)=? AND ` + notDeleted
+
+	result, err := tx.Exec(query,
+		task.UserID, task.Title, task.Description, task.StartDate, task.DueDate, task.IsCompleted,
+		task.Category, task.Priority, task.DeviceID, task.RecordID, updatedAt, task.DailyProgress,
+		task.ApprovalStatus, task.ApprovalComment, task.CompletionRequestedAt, task.ReviewedBy, task.ReviewedAt,
+		task.Points, task.RRule, task.ExDates, task.ReminderOffsets, revision, string(fieldRevisionsJSON),
+		task.WorkProgress, task.TimeSpent, task.ProgressNotes, task.TimeZone,
+		getTaskIDString(task), task.Revision)
+	if err != nil {
+		log.Printf("❌ 更新任务失败: %v", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		// 读取之后被其他请求抢先修改
+		tx.Rollback()
+		if current, err = s.Get(getTaskIDString(task)); err != nil {
+			return err
+		}
+		return &ConflictError{Current: current}
+	}
+
+	saved := *task
+	saved.UpdatedAt = updatedAt
+	saved.Revision = revision
+	saved.FieldRevisions = fieldRevisions
+	if err := saveTaskHistory(tx, &saved); err != nil {
+		return err
+	}
+	if err := indexTask(tx, &saved); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	*task = saved
+	return nil
+}
+
+// Delete 软删除，任务保留为墓碑直到超过回收站保留期
+func (s *SQLiteTaskStore) Delete(id, deletedBy string) error {
+	now := time.Now().UTC().Format(timeLayout)
+	result, err := s.db.Exec("UPDATE tasks SET deleted_at=?, deleted_by=?, updated_at=?, revision=COALESCE(revision, 1) + 1 WHERE id=? AND "+notDeleted,
+		now, deletedBy, now, id)
+	if err != nil {
+		log.Printf("❌ 删除任务失败: %v", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTaskNotFound
+	}
+	// 回收站中的任务不出现在搜索结果中
+	return unindexTask(s.db, id)
+}
+
+// Restore 从回收站恢复任务
+func (s *SQLiteTaskStore) Restore(id string) error {
+	now := time.Now().UTC().Format(timeLayout)
+	result, err := s.db.Exec(`UPDATE tasks SET deleted_at='', deleted_by='', updated_at=?, revision=COALESCE(revision, 1) + 1
+		WHERE id=? AND COALESCE(deleted_at, '') != ''`,
+		now, id)
+	if err != nil {
+		log.Printf("❌ 恢复任务失败: %v", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTaskNotFound
+	}
+
+	task, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	return indexTask(s.db, task)
+}
+
+// PurgeDeleted 彻底删除before之前删除的任务，连同重复任务的实例记录、提醒发送记录、历史版本和每日进度
+func (s *SQLiteTaskStore) PurgeDeleted(before time.Time) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	cutoff := before.UTC().Format(timeLayout)
+	expired := "SELECT id FROM tasks WHERE COALESCE(deleted_at, '') != '' AND deleted_at < ?"
+	for _, table := range []string{"task_occurrences", "reminders_sent", "task_history", "task_daily_progress"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE task_id IN ("+expired+")", cutoff); err != nil {
+			return 0, err
+		}
+	}
+	result, err := tx.Exec("DELETE FROM tasks WHERE COALESCE(deleted_at, '') != '' AND deleted_at < ?", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// FindDuplicate 按标题+设备查找同一用户的任务，不存在时返回nil, nil
+func (s *SQLiteTaskStore) FindDuplicate(title, deviceID, userID string) (*Task, error) {
+	task, err := s.queryOne(notDeleted+" AND title = ? AND device_id = ? AND user_id = ? ORDER BY created_at DESC LIMIT 1",
+		title, deviceID, userID)
+	if err == ErrTaskNotFound {
+		return nil, nil
+	}
+	return task, err
+}
+
+// FindByRecordID 按CloudKit record_id查找任务，不存在时返回nil, nil
+func (s *SQLiteTaskStore) FindByRecordID(recordID, userID string) (*Task, error) {
+	task, err := s.queryOne(notDeleted+" AND record_id = ? AND user_id = ? LIMIT 1", recordID, userID)
+	if err == ErrTaskNotFound {
+		return nil, nil
+	}
+	return task, err
+}
+
+// 解析客户端提交的任务时间：iOS为ISO8601，网页为toISOString或datetime-local，数据库为timeLayout
+func parseTaskTime(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04", timeLayout, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
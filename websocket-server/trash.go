@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// 回收站REST处理器: GET /api/trash，家长可以通过user_id查看孩子的回收站
+func getTrashHandler(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	ownerID := r.URL.Query().Get("user_id")
+	if ownerID == "" {
+		ownerID = user.ID
+	}
+
+	tasks, err := taskService.ListTrash(user.ID, ownerID)
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tasks)
+}
+
+// 恢复任务: POST /api/tasks/{id}/restore
+func restoreTaskHandler(w http.ResponseWriter, r *http.Request) {
+	task, err := taskService.RestoreTask(userFromContext(r.Context()).ID, mux.Vars(r)["id"])
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
+
+// 每天清理一次超过保留期的已删除任务
+func purgeTrash(store TaskStore, retention time.Duration) {
+	for {
+		n, err := store.PurgeDeleted(time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("❌ 清理回收站失败: %v", err)
+		} else if n > 0 {
+			log.Printf("🧹 回收站清理: 彻底删除 %d 个任务", n)
+		}
+		time.Sleep(24 * time.Hour)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 客户端发送队列溢出时的关闭码，客户端应重新连接并等待tasks_sync
+const closeCodeResync = 4000
+
+// wsConn 包装一个WebSocket连接。gorilla/websocket不允许并发写，
+// 所有发出的消息（tasks_sync、pong、ack、广播）都通过Send进入队列，由writePump逐条写出
+type wsConn struct {
+	ws   *websocket.Conn
+	send chan WSMessage
+	user string
+
+	mu     sync.Mutex
+	closed bool
+	resync bool // 队列溢出关闭，断开时通知客户端重新同步
+}
+
+func newWSConn(ws *websocket.Conn, userID string) *wsConn {
+	c := &wsConn{
+		ws:   ws,
+		send: make(chan WSMessage, config.SendQueueSize),
+		user: userID,
+	}
+	c.extendReadDeadline()
+	ws.SetPongHandler(func(string) error {
+		c.extendReadDeadline()
+		return nil
+	})
+	go c.writePump()
+	return c
+}
+
+// Send 把消息放入发送队列，不会阻塞。队列已满时关闭连接并要求客户端重新同步，
+// 返回false表示消息没有入队（连接已关闭或被丢弃）
+func (c *wsConn) Send(message WSMessage) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+	select {
+	case c.send <- message:
+		return true
+	default:
+		log.Printf("⚠️ 客户端发送队列已满，断开并要求重新同步: user=%s", c.user)
+		c.closeLocked(true)
+		return false
+	}
+}
+
+// Close 停止发送，writePump写完队列中剩余的消息后关闭底层连接
+func (c *wsConn) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeLocked(false)
+}
+
+func (c *wsConn) closeLocked(resync bool) {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.resync = resync
+	close(c.send)
+}
+
+// 唯一的写协程，定时发送ping；send关闭后断开连接
+func (c *wsConn) writePump() {
+	ticker := time.NewTicker(config.PingInterval)
+	defer func() {
+		ticker.Stop()
+		c.ws.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(config.WriteWait))
+			if !ok {
+				c.mu.Lock()
+				resync := c.resync
+				c.mu.Unlock()
+				if resync {
+					c.ws.WriteMessage(websocket.CloseMessage,
+						websocket.FormatCloseMessage(closeCodeResync, "resync"))
+				}
+				return
+			}
+			if err := c.ws.WriteJSON(message); err != nil {
+				log.Printf("❌ 发送消息失败: user=%s, %v", c.user, err)
+				return
+			}
+
+		case <-ticker.C:
+			c.ws.SetWriteDeadline(time.Now().Add(config.WriteWait))
+			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Printf("💔 发送ping失败: user=%s, %v", c.user, err)
+				return
+			}
+		}
+	}
+}
+
+// ReadJSON 读取下一条消息，只能在读协程中调用。
+// 在PongWait内收不到任何消息（包括pong）视为连接已断开
+func (c *wsConn) ReadJSON(v interface{}) error {
+	if err := c.ws.ReadJSON(v); err != nil {
+		return err
+	}
+	c.extendReadDeadline()
+	return nil
+}
+
+func (c *wsConn) extendReadDeadline() {
+	c.ws.SetReadDeadline(time.Now().Add(config.PongWait))
+}
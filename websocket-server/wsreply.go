@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"log"
+)
+
+var (
+	// ErrInvalidMessage WebSocket消息格式错误
+	ErrInvalidMessage = errors.New("消息格式错误")
+	// ErrUnknownMessage 未知的WebSocket消息类型
+	ErrUnknownMessage = errors.New("未知消息类型")
+)
+
+// WebSocket错误码，客户端据此决定重试还是放弃
+const (
+	CodeInvalidMessage      = "invalid_message"
+	CodeUnknownType         = "unknown_type"
+	CodeNotFound            = "not_found"
+	CodeForbidden           = "forbidden"
+	CodeInvalidRecurrence   = "invalid_recurrence"
+	CodeInvalidReminder     = "invalid_reminder"
+	CodeInvalidPatch        = "invalid_patch"
+	CodeInvalidFilter       = "invalid_filter"
+	CodeInvalidProgress     = "invalid_progress"
+	CodeNoSuchOccurrence    = "occurrence_not_found"
+	CodeNoPendingCompletion = "no_pending_completion"
+	CodeIdempotencyMismatch = "idempotency_key_reused"
+	CodeRequestInProgress   = "request_in_progress"
+	CodeConflict            = "conflict"
+	CodeInternal            = "internal_error"
+)
+
+// 将业务层错误映射为错误码，与writeTaskError的HTTP状态码对应
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidMessage):
+		return CodeInvalidMessage
+	case errors.Is(err, ErrUnknownMessage):
+		return CodeUnknownType
+	case errors.Is(err, ErrTaskNotFound):
+		return CodeNotFound
+	case errors.Is(err, ErrForbidden):
+		return CodeForbidden
+	case errors.Is(err, ErrInvalidRecurrence):
+		return CodeInvalidRecurrence
+	case errors.Is(err, ErrInvalidReminder):
+		return CodeInvalidReminder
+	case errors.Is(err, ErrInvalidPatch):
+		return CodeInvalidPatch
+	case errors.Is(err, ErrInvalidFilter):
+		return CodeInvalidFilter
+	case errors.Is(err, ErrInvalidProgress):
+		return CodeInvalidProgress
+	case errors.Is(err, ErrNotRecurring), errors.Is(err, ErrNoSuchOccurrence):
+		return CodeNoSuchOccurrence
+	case errors.Is(err, ErrNoPendingCompletion):
+		return CodeNoPendingCompletion
+	case errors.Is(err, ErrIdempotencyMismatch):
+		return CodeIdempotencyMismatch
+	case errors.Is(err, ErrIdempotencyInProgress):
+		return CodeRequestInProgress
+	case errors.Is(err, ErrConflict):
+		return CodeConflict
+	default:
+		return CodeInternal
+	}
+}
+
+// 回复发送者：成功为ack，失败为error，只发给发出请求的连接。
+// 没有request_id的旧客户端不回复，保持原来的行为
+func (c *Client) reply(request WSMessage, result interface{}, err error) {
+	if err != nil {
+		log.Printf("❌ 处理%s消息失败: %v", request.Type, err)
+	}
+	if request.RequestID == "" {
+		return
+	}
+	c.conn.Send(replyMessage(request, result, err))
+}
+
+// 版本冲突回复conflict消息，带上服务器当前的任务
+func replyMessage(request WSMessage, result interface{}, err error) WSMessage {
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		return WSMessage{
+			Type:      "conflict",
+			RequestID: request.RequestID,
+			Data: map[string]interface{}{
+				"for":     request.Type,
+				"code":    CodeConflict,
+				"message": err.Error(),
+				"current": conflict.Current,
+				"fields":  conflict.Fields,
+			},
+		}
+	}
+	if err != nil {
+		return WSMessage{
+			Type:      "error",
+			RequestID: request.RequestID,
+			Data: map[string]interface{}{
+				"for":     request.Type,
+				"code":    errorCode(err),
+				"message": err.Error(),
+			},
+		}
+	}
+
+	return WSMessage{
+		Type:      "ack",
+		RequestID: request.RequestID,
+		Data: map[string]interface{}{
+			"for":    request.Type,
+			"result": result,
+		},
+	}
+}